@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Base path of the versioned JSON API
+const APIPrefix = "/api/v1"
+
+// Media type of JSON request and response bodies
+const JSONContentType = "application/json"
+
+// Maximum size of a JSON request body
+const maxAPIRequestSize = 10 << 20
+
+// APIPost is the JSON representation of a post.
+type APIPost struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Content  string    `json:"content"`
+	Tags     []Tag     `json:"tags"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	// Also sent as the ETag of the post
+	Version string `json:"version"`
+}
+
+func newAPIPost(p *Post) *APIPost {
+	tags := p.Tags
+	if tags == nil {
+		tags = []Tag{}
+	}
+	return &APIPost{
+		ID:       p.ID,
+		Title:    p.Title,
+		Content:  p.Content,
+		Tags:     tags,
+		Created:  p.CreatedTime,
+		Modified: p.ModifiedTime,
+		Version:  p.Version(),
+	}
+}
+
+// postETag returns the entity tag of the current version of a post.
+func postETag(p *Post) string {
+	return `"` + p.Version() + `"`
+}
+
+// ifMatchVersion returns the version of a post an update request is based
+// on, from its If-Match header. It is empty if the header is missing, and
+// the current version if the header matches it.
+func ifMatchVersion(r *http.Request, p *Post) string {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return ""
+	}
+
+	current := p.Version()
+	var first string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return current
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		if tag == current {
+			return current
+		}
+		if first == "" {
+			first = tag
+		}
+	}
+	return first
+}
+
+// APIPostInput is the body of requests creating or updating a post. Fields
+// left out of a partial update keep their current value.
+type APIPostInput struct {
+	Title   *string `json:"title"`
+	Content *string `json:"content"`
+	Tags    *[]Tag  `json:"tags"`
+
+	// Read-only fields of APIPost, so a post can be sent back as it was
+	// received. They are ignored.
+	ID       json.RawMessage `json:"id"`
+	Created  json.RawMessage `json:"created"`
+	Modified json.RawMessage `json:"modified"`
+	Version  json.RawMessage `json:"version"`
+}
+
+// apply sets the fields of a post from the input. Unless partial, fields left
+// out of the input are cleared.
+func (in *APIPostInput) apply(p *Post, partial bool) error {
+	if in.Title != nil || !partial {
+		p.Title = deref(in.Title)
+	}
+	if in.Content != nil || !partial {
+		p.Content = deref(in.Content)
+	}
+	if in.Tags != nil || !partial {
+		p.Tags = deref(in.Tags)
+	}
+
+	if strings.TrimSpace(p.Title) == "" {
+		return errors.New("title is required")
+	}
+	return nil
+}
+
+func deref[T any](v *T) (zero T) {
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+// APIPostList is a page of posts.
+type APIPostList struct {
+	Posts []*APIPost `json:"posts"`
+	// URL of the next page, if there are more posts
+	Next string `json:"next,omitempty"`
+}
+
+// APITag is a tag and its usage.
+type APITag struct {
+	Name     Tag       `json:"name"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// APIPostRef refers to a post without its content.
+type APIPostRef struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// APIFolder is a folder of the posts folder tree.
+type APIFolder struct {
+	Name        string        `json:"name"`
+	Path        string        `json:"path"`
+	Description string        `json:"description,omitempty"`
+	Icon        string        `json:"icon,omitempty"`
+	IndexPostID string        `json:"index_post_id,omitempty"`
+	SortBy      PostSortKey   `json:"sort,omitempty"`
+	Posts       []*APIPostRef `json:"posts"`
+	Children    []*APIFolder  `json:"children"`
+}
+
+func newAPIFolder(node *Node) *APIFolder {
+	f := &APIFolder{
+		Name:     node.Label,
+		Path:     node.Path,
+		Posts:    []*APIPostRef{},
+		Children: []*APIFolder{},
+	}
+	if s := node.Settings; s != nil {
+		f.Description = s.Description
+		f.Icon = s.Icon
+		f.IndexPostID = s.IndexPostID
+		f.SortBy = s.SortBy
+	}
+	for _, p := range node.Value {
+		f.Posts = append(f.Posts, &APIPostRef{ID: p.ID, Title: p.Title})
+	}
+	for _, child := range node.Children {
+		f.Children = append(f.Children, newAPIFolder(child))
+	}
+	return f
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", JSONContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error: writeJSON: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &apiError{Status: status, Message: err.Error()})
+}
+
+// apiErrorStatus returns the HTTP status code of an error returned by the
+// posts service.
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrExist), errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// isJSONRequest reports whether the request body is JSON. Requests without a
+// content type are assumed to be.
+func isJSONRequest(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && mt == JSONContentType
+}
+
+// negotiateContentType returns the offered media type most preferred by the
+// Accept header of a request. The first offer wins ties, and is returned if
+// no offer is acceptable.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	best, bestQ := offers[0], -1.0
+	for _, offer := range offers {
+		q := acceptQuality(r.Header.Get("Accept"), offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if bestQ <= 0 {
+		return offers[0]
+	}
+	return best
+}
+
+// acceptQuality returns the quality factor of a media type in an Accept
+// header, using the most specific matching media range.
+func acceptQuality(accept, mediaType string) float64 {
+	if accept == "" {
+		accept = "*/*"
+	}
+
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mr, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		var s int
+		switch mr {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return q
+}
+
+// wantsJSON reports whether the client prefers a JSON response over HTML.
+func wantsJSON(r *http.Request) bool {
+	return negotiateContentType(r, "text/html", JSONContentType) == JSONContentType
+}
+
+// decodeJSONBody decodes a JSON request body into v.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) (int, error) {
+	if !isJSONRequest(r) {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type, expected %s", JSONContentType)
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return http.StatusBadRequest, errors.New("request body must be a JSON object")
+		}
+		return http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+	}
+	return 0, nil
+}
+
+func (app *App) APIListPostsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptionsFromRequest(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Drafts are left out of exports, unless asked for, or filtered on
+		drafts, err := boolFormValue(r, "drafts")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		opts.ExcludeDrafts = !drafts && !slices.Contains(opts.TagsFilter, string(DraftTag))
+
+		// Fetch an extra post to tell if there is a next page
+		opts.Limit++
+		posts, err := app.posts.ListPosts(opts)
+		if err != nil {
+			log.Printf("error: APIListPostsHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		opts.Limit--
+
+		list := &APIPostList{Posts: []*APIPost{}}
+		if len(posts) > opts.Limit {
+			posts = posts[:opts.Limit]
+			list.Next = pageURL(r, opts.Offset+opts.Limit)
+		}
+		for _, p := range posts {
+			list.Posts = append(list.Posts, newAPIPost(p))
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func (app *App) APIGetPostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		post, err := app.posts.GetPost(postID)
+		if err != nil {
+			log.Printf("error: APIGetPostHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		w.Header().Set("ETag", postETag(post))
+		writeJSON(w, http.StatusOK, newAPIPost(post))
+	}
+}
+
+func (app *App) APICreatePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in APIPostInput
+		if status, err := decodeJSONBody(w, r, &in); err != nil {
+			writeJSONError(w, status, err)
+			return
+		}
+
+		post := new(Post)
+		if err := in.apply(post, false); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := app.posts.CreatePost(post); err != nil {
+			log.Printf("error: APICreatePostHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		w.Header().Set("Location", APIPrefix+"/posts/"+post.ID)
+		w.Header().Set("ETag", postETag(post))
+		writeJSON(w, http.StatusCreated, newAPIPost(post))
+	}
+}
+
+// APIUpdatePostHandler replaces the title, content and tags of a post, or
+// only those given if partial. Updates with an If-Match header are rejected
+// if the post was changed since that version.
+func (app *App) APIUpdatePostHandler(partial bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		post, err := app.posts.GetPost(postID)
+		if err != nil {
+			log.Printf("error: APIUpdatePostHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		baseVersion := ifMatchVersion(r, post)
+
+		var in APIPostInput
+		if status, err := decodeJSONBody(w, r, &in); err != nil {
+			writeJSONError(w, status, err)
+			return
+		}
+		if err := in.apply(post, partial); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		post.BaseVersion = baseVersion
+
+		if err := app.posts.UpdatePost(post); err != nil {
+			log.Printf("error: APIUpdatePostHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		w.Header().Set("ETag", postETag(post))
+		writeJSON(w, http.StatusOK, newAPIPost(post))
+	}
+}
+
+// APIDeletePostHandler moves a post to the trash.
+func (app *App) APIDeletePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		if err := app.posts.DeletePost(postID); err != nil {
+			log.Printf("error: APIDeletePostHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// APIListTagsHandler lists all tags with their usage. Functional tags are
+// left out, unless asked for.
+func (app *App) APIListTagsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functional, err := boolFormValue(r, "functional")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		sortBy, err := ParseTagSortKey(r.FormValue("sort"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		infos, err := app.posts.ListTags(&ListTagOptions{
+			IgnoreFunctional: !functional,
+			SortBy:           sortBy,
+		})
+		if err != nil {
+			log.Printf("error: APIListTagsHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+
+		tags := []*APITag{}
+		for _, t := range infos {
+			tags = append(tags, &APITag{Name: t.Name, Count: t.Count, LastUsed: t.LastUsed})
+		}
+
+		writeJSON(w, http.StatusOK, tags)
+	}
+}
+
+// APIFolderTreeHandler returns the posts folder tree. The root folder has an
+// empty name. Archived posts are left out, unless asked for.
+func (app *App) APIFolderTreeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archived, err := boolFormValue(r, "archived")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		tree, err := app.posts.GetPostsFolderTree()
+		if err != nil {
+			log.Printf("error: APIFolderTreeHandler: %v", err)
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		if !archived {
+			tree = tree.Filter(notArchived)
+		}
+
+		writeJSON(w, http.StatusOK, newAPIFolder(tree))
+	}
+}
+
+// APINotFoundHandler answers requests matching none of the API routes with
+// a JSON error: 405 if the path exists with other methods, 404 otherwise.
+func (app *App) APINotFoundHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if methods := app.router.Methods(r.URL.Path); len(methods) > 0 {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%s: %w", r.URL.Path, fs.ErrNotExist))
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI document describing the API.
+func (app *App) OpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := staticFS.ReadFile("static/openapi.json")
+		if err != nil {
+			log.Printf("error: OpenAPIHandler: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", JSONContentType)
+		w.Write(b)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestAPI(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+
+	// do sends a request to the app and decodes the JSON response body into v,
+	// unless nil.
+	do := func(t *testing.T, method, url, contentType, body string, v any) *http.Response {
+		is := is.New(t)
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		resp := w.Result()
+		if v != nil {
+			is.Equal(resp.Header.Get("Content-Type"), JSONContentType)
+			is.NoErr(json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp
+	}
+
+	var post APIPost
+
+	t.Run("create a post", func(t *testing.T) {
+		is := is.New(t)
+		resp := do(t, http.MethodPost, "/api/v1/posts", JSONContentType,
+			`{"title": "foo", "content": "bar", "tags": ["a", "_dir:/x/y"]}`, &post)
+		is.Equal(resp.StatusCode, 201)
+		is.Equal(resp.Header.Get("Location"), "/api/v1/posts/"+post.ID)
+		is.Equal(post.Title, "foo")
+		is.Equal(post.Tags, []Tag{"a", "_dir:/x/y"})
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for _, tc := range []struct {
+			method, url, contentType, body string
+			status                         int
+		}{
+			{http.MethodPost, "/api/v1/posts", JSONContentType, `{"content": "no title"}`, 400},
+			{http.MethodPost, "/api/v1/posts", JSONContentType, `{"title": "foo", "bar": 1}`, 400},
+			{http.MethodPost, "/api/v1/posts", JSONContentType, `{"title": `, 400},
+			{http.MethodPost, "/api/v1/posts", JSONContentType, ``, 400},
+			{http.MethodPost, "/api/v1/posts", "text/plain", `{"title": "foo"}`, 415},
+			{http.MethodPatch, "/api/v1/posts/" + post.ID, JSONContentType, `{"title": ""}`, 400},
+			{http.MethodGet, "/api/v1/posts/nope", "", ``, 404},
+			{http.MethodPut, "/api/v1/posts/nope", JSONContentType, `{"title": "foo"}`, 404},
+			{http.MethodDelete, "/api/v1/posts/nope", "", ``, 404},
+			{http.MethodGet, "/api/v1/posts?sort=size", "", ``, 400},
+			{http.MethodGet, "/api/v1/posts?limit=0", "", ``, 400},
+			{http.MethodGet, "/api/v1/tags?functional=maybe", "", ``, 400},
+			{http.MethodGet, "/api/v1/nope", "", ``, 404},
+			{http.MethodGet, "/api/v1", "", ``, 404},
+			{http.MethodPut, "/api/v1/posts", JSONContentType, `{"title": "foo"}`, 405},
+			{http.MethodPost, "/api/v1/tags", "", ``, 405},
+		} {
+			t.Run(fmt.Sprintf("%s %s %s", tc.method, tc.url, tc.body), func(t *testing.T) {
+				is := is.New(t)
+				var apiErr apiError
+				resp := do(t, tc.method, tc.url, tc.contentType, tc.body, &apiErr)
+				is.Equal(resp.StatusCode, tc.status)
+				is.Equal(apiErr.Status, tc.status)
+				is.True(apiErr.Message != "")
+			})
+		}
+	})
+
+	t.Run("get a post", func(t *testing.T) {
+		is := is.New(t)
+		var got APIPost
+		resp := do(t, http.MethodGet, "/api/v1/posts/"+post.ID, "", "", &got)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(got.Content, "bar")
+	})
+
+	t.Run("patch keeps fields left out", func(t *testing.T) {
+		is := is.New(t)
+		var got APIPost
+		resp := do(t, http.MethodPatch, "/api/v1/posts/"+post.ID, JSONContentType, `{"content": "baz"}`, &got)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(got.Title, "foo")
+		is.Equal(got.Content, "baz")
+		is.Equal(len(got.Tags), 2)
+	})
+
+	t.Run("put replaces all fields", func(t *testing.T) {
+		is := is.New(t)
+		var got APIPost
+		resp := do(t, http.MethodPut, "/api/v1/posts/"+post.ID, JSONContentType, `{"title": "qux"}`, &got)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(got.Title, "qux")
+		is.Equal(got.Content, "")
+		is.Equal(got.Tags, []Tag{})
+
+		resp = do(t, http.MethodPut, "/api/v1/posts/"+post.ID, JSONContentType,
+			`{"title": "foo", "content": "bar", "tags": ["a", "_dir:/x/y"]}`, &got)
+		is.Equal(resp.StatusCode, 200)
+	})
+
+	t.Run("posts can be sent back as they were received", func(t *testing.T) {
+		is := is.New(t)
+		var got APIPost
+		do(t, http.MethodGet, "/api/v1/posts/"+post.ID, "", "", &got)
+		got.Content = "changed"
+		b, err := json.Marshal(got)
+		is.NoErr(err)
+
+		var updated APIPost
+		resp := do(t, http.MethodPut, "/api/v1/posts/"+post.ID, JSONContentType, string(b), &updated)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(updated.ID, post.ID)
+		is.Equal(updated.Content, "changed")
+		is.True(updated.Version != got.Version)
+
+		resp = do(t, http.MethodPut, "/api/v1/posts/"+post.ID, JSONContentType,
+			`{"title": "foo", "content": "bar", "tags": ["a", "_dir:/x/y"]}`, &got)
+		is.Equal(resp.StatusCode, 200)
+	})
+
+	t.Run("wrong methods are named in the Allow header", func(t *testing.T) {
+		is := is.New(t)
+		resp := do(t, http.MethodPost, "/api/v1/posts/"+post.ID, JSONContentType, `{}`, nil)
+		is.Equal(resp.StatusCode, 405)
+		is.Equal(resp.Header.Get("Allow"), "GET, PUT, PATCH, DELETE")
+	})
+
+	t.Run("stale updates are rejected", func(t *testing.T) {
+		is := is.New(t)
+		var got APIPost
+		resp := do(t, http.MethodGet, "/api/v1/posts/"+post.ID, "", "", &got)
+		etag := resp.Header.Get("ETag")
+		is.Equal(etag, `"`+got.Version+`"`)
+
+		update := func(ifMatch, body string) *http.Response {
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/posts/"+post.ID, strings.NewReader(body))
+			r.Header.Set("If-Match", ifMatch)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			return w.Result()
+		}
+
+		resp = update(etag, `{"content": "baz"}`)
+		is.Equal(resp.StatusCode, 200)
+		is.True(resp.Header.Get("ETag") != etag)
+
+		resp = update(etag, `{"content": "qux"}`)
+		is.Equal(resp.StatusCode, 409)
+
+		is.Equal(update("*", `{"content": "bar"}`).StatusCode, 200)
+	})
+
+	t.Run("list posts in pages", func(t *testing.T) {
+		is := is.New(t)
+		for i := 0; i < 2; i++ {
+			resp := do(t, http.MethodPost, "/api/v1/posts", JSONContentType,
+				fmt.Sprintf(`{"title": "post%d", "tags": ["a", "b"]}`, i), nil)
+			is.Equal(resp.StatusCode, 201)
+		}
+
+		var page APIPostList
+		resp := do(t, http.MethodGet, "/api/v1/posts?sort=title&limit=2", "", "", &page)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(len(page.Posts), 2)
+		is.Equal(page.Posts[0].Title, "foo")
+		is.True(page.Next != "")
+
+		var next APIPostList
+		resp = do(t, http.MethodGet, page.Next, "", "", &next)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(len(next.Posts), 1)
+		is.Equal(next.Posts[0].Title, "post1")
+		is.Equal(next.Next, "")
+	})
+
+	t.Run("list tags with counts", func(t *testing.T) {
+		is := is.New(t)
+		var tags []APITag
+		resp := do(t, http.MethodGet, "/api/v1/tags", "", "", &tags)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(len(tags), 2)
+		is.Equal(tags[0].Name, Tag("a"))
+		is.Equal(tags[0].Count, 3)
+		is.True(!tags[0].LastUsed.IsZero())
+
+		do(t, http.MethodGet, "/api/v1/tags?sort=count", "", "", &tags)
+		is.Equal(tags[0].Name, Tag("a"))
+
+		do(t, http.MethodGet, "/api/v1/tags?functional=true", "", "", &tags)
+		is.Equal(len(tags), 3)
+	})
+
+	t.Run("folder tree", func(t *testing.T) {
+		is := is.New(t)
+		var root APIFolder
+		resp := do(t, http.MethodGet, "/api/v1/tree", "", "", &root)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(root.Name, "")
+		is.Equal(len(root.Children), 1)
+
+		y := root.Children[0].Children[0]
+		is.Equal(y.Path, "x/y")
+		is.Equal(y.Posts, []*APIPostRef{{ID: post.ID, Title: "foo"}})
+	})
+
+	t.Run("delete a post", func(t *testing.T) {
+		is := is.New(t)
+		resp := do(t, http.MethodDelete, "/api/v1/posts/"+post.ID, "", "", nil)
+		is.Equal(resp.StatusCode, 204)
+
+		resp = do(t, http.MethodGet, "/api/v1/posts/"+post.ID, "", "", &apiError{})
+		is.Equal(resp.StatusCode, 404)
+	})
+
+	t.Run("openapi document", func(t *testing.T) {
+		is := is.New(t)
+		var doc map[string]any
+		resp := do(t, http.MethodGet, "/api/v1/openapi.json", "", "", &doc)
+		is.Equal(resp.StatusCode, 200)
+		is.Equal(doc["openapi"], "3.0.3")
+	})
+
+	t.Run("post pages negotiate JSON", func(t *testing.T) {
+		is := is.New(t)
+		var created APIPost
+		resp := do(t, http.MethodPost, "/posts/", JSONContentType, `{"title": "negotiated"}`, &created)
+		is.Equal(resp.StatusCode, 201)
+
+		r := httptest.NewRequest(http.MethodGet, "/posts/"+created.ID, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		is.Equal(w.Result().Header.Get("Content-Type"), JSONContentType)
+
+		r = httptest.NewRequest(http.MethodGet, "/posts/"+created.ID, nil)
+		r.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		is.True(strings.Contains(w.Body.String(), "<html"))
+	})
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                     "text/html",
+		"*/*":                                  "text/html",
+		"application/json":                     "application/json",
+		"text/html, application/json":          "text/html",
+		"text/html;q=0.5, application/*":       "application/json",
+		"application/json;q=0, */*":            "text/html",
+		"image/png":                            "text/html",
+		"text/*;q=0.1, application/json;q=0.2": "application/json",
+	} {
+		t.Run(accept, func(t *testing.T) {
+			is := is.New(t)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", accept)
+			is.Equal(negotiateContentType(r, "text/html", "application/json"), want)
+		})
+	}
+}
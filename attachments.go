@@ -0,0 +1,512 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the directory, relative to the data dir, where files attached to
+// posts are kept. Every post has its own sub-directory.
+const AttachmentsDirName = ".attachments"
+
+// Default maximum size of a single attachment, in bytes
+const DefaultMaxAttachmentSize = 10 << 20
+
+// Maximum number of files in a single upload
+const maxUploadFiles = 20
+
+var ErrAttachmentTooLarge = errors.New("attachment is too large")
+
+// Content types shown in the browser. Anything else, like HTML or SVG that
+// could run scripts, is downloaded instead.
+var inlineContentTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// An Attachment is a file attached to a post.
+type Attachment struct {
+	PostID string
+	Name   string
+	// Size in bytes
+	Size int64
+	// Content type, detected from the content of the file
+	ContentType  string
+	ModifiedTime time.Time
+}
+
+func (a *Attachment) URL() string {
+	return "/posts/" + a.PostID + "/attachments/" + url.PathEscape(a.Name)
+}
+
+// IsReferencedIn reports whether content, like that of the post, links to
+// or embeds the attachment.
+func (a *Attachment) IsReferencedIn(content string) bool {
+	return strings.Contains(content, a.URL()) ||
+		strings.Contains(content, "/posts/"+a.PostID+"/attachments/"+a.Name)
+}
+
+// mediaType returns the content type without parameters.
+func (a *Attachment) mediaType() string {
+	mt, _, _ := mime.ParseMediaType(a.ContentType)
+	return mt
+}
+
+func (a *Attachment) IsImage() bool {
+	mt := a.mediaType()
+	return strings.HasPrefix(mt, "image/") && inlineContentTypes[mt] != ""
+}
+
+// isInline reports whether the attachment is safe to show in the browser.
+func (a *Attachment) isInline() bool {
+	return inlineContentTypes[a.mediaType()] != ""
+}
+
+// Markdown returns the Markdown syntax embedding the attachment in a post,
+// as an image or as a link.
+func (a *Attachment) Markdown() string {
+	link := "[" + a.Name + "](" + a.URL() + ")"
+	if a.IsImage() {
+		return "!" + link
+	}
+	return link
+}
+
+// HumanSize returns the size in a human readable unit.
+func (a *Attachment) HumanSize() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", a.Size)
+}
+
+// AttachmentStore keeps the files attached to posts in the data dir, next
+// to the posts. It works the same with all storage backends.
+type AttachmentStore struct {
+	root string
+	// Maximum size of a single attachment, in bytes
+	maxSize int64
+	// Serializes uploads, so each gets a name of its own
+	mu sync.Mutex
+}
+
+func NewAttachmentStore(root string, maxSize int64) *AttachmentStore {
+	return &AttachmentStore{root: root, maxSize: maxSize}
+}
+
+func (s *AttachmentStore) dir(postID string) string {
+	return path.Join(s.root, AttachmentsDirName, postID)
+}
+
+// file returns the path of an attachment, or an error if the post ID or
+// name could refer to a file outside of the attachments of the post.
+func (s *AttachmentStore) file(postID, name string) (string, error) {
+	if postID == "" || strings.ContainsAny(postID, `/\.`) || name != cleanAttachmentName(name) {
+		return "", fmt.Errorf("attachment %s: %w", name, fs.ErrNotExist)
+	}
+	return path.Join(s.dir(postID), name), nil
+}
+
+// cleanAttachmentName turns an uploaded file name into one that is safe to
+// use in the file system, URLs and Markdown.
+func cleanAttachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if isWordRune(r) || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+	// No hidden files
+	name = strings.TrimLeft(name, ".-")
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+// statAttachment describes an attachment file, detecting its content type.
+func statAttachment(postID, filepath string) (*Attachment, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return &Attachment{
+		PostID:       postID,
+		Name:         fi.Name(),
+		Size:         fi.Size(),
+		ContentType:  http.DetectContentType(head[:n]),
+		ModifiedTime: fi.ModTime(),
+	}, nil
+}
+
+// Save stores an attachment of a post. The name is cleaned up, given an
+// extension matching the content if it has none, and made unique among the
+// attachments of the post.
+func (s *AttachmentStore) Save(postID, name string, r io.Reader) (*Attachment, error) {
+	b, err := io.ReadAll(io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Save: %w", err)
+	}
+	if int64(len(b)) > s.maxSize {
+		return nil, fmt.Errorf("Save: %w, the limit is %d bytes", ErrAttachmentTooLarge, s.maxSize)
+	}
+
+	name = cleanAttachmentName(name)
+	if path.Ext(name) == "" {
+		mt, _, _ := mime.ParseMediaType(http.DetectContentType(b))
+		name += inlineContentTypes[mt]
+	}
+
+	filepath, err := s.file(postID, name)
+	if err != nil {
+		return nil, fmt.Errorf("Save: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir(postID), 0750); err != nil {
+		return nil, fmt.Errorf("Save: %w", err)
+	}
+
+	ext := path.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Save: %w", err)
+		}
+		filepath = path.Join(s.dir(postID), fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+
+	if err := writeFileAtomic(filepath, b, DefaultFileMode); err != nil {
+		return nil, fmt.Errorf("Save: %w", err)
+	}
+
+	a, err := statAttachment(postID, filepath)
+	if err != nil {
+		return nil, fmt.Errorf("Save: %w", err)
+	}
+	return a, nil
+}
+
+// List returns all attachments of a post, ordered by name.
+func (s *AttachmentStore) List(postID string) ([]*Attachment, error) {
+	entries, err := os.ReadDir(s.dir(postID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("List: %w", err)
+	}
+
+	var attachments []*Attachment
+	for _, e := range entries {
+		// Uploads in progress are hidden
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		a, err := statAttachment(postID, path.Join(s.dir(postID), e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("List: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+
+	return attachments, nil
+}
+
+// Open opens an attachment for reading.
+func (s *AttachmentStore) Open(postID, name string) (*os.File, *Attachment, error) {
+	filepath, err := s.file(postID, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Open: %w", err)
+	}
+
+	a, err := statAttachment(postID, filepath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Open: %w", err)
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Open: %w", err)
+	}
+
+	return f, a, nil
+}
+
+// Delete removes an attachment.
+func (s *AttachmentStore) Delete(postID, name string) error {
+	filepath, err := s.file(postID, name)
+	if err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if err := os.Remove(filepath); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	return nil
+}
+
+// RemoveAll removes all attachments of a post.
+func (s *AttachmentStore) RemoveAll(postID string) error {
+	if !plainIDPattern.MatchString(postID) {
+		return fmt.Errorf("RemoveAll: post %q: %w", postID, fs.ErrNotExist)
+	}
+	if err := os.RemoveAll(s.dir(postID)); err != nil {
+		return fmt.Errorf("RemoveAll: %w", err)
+	}
+	return nil
+}
+
+// RemoveOrphans removes the attachments of posts that no longer exist,
+// neither in the posts nor in the trash. Returns the IDs of those posts.
+// Attachments of existing posts are never removed, even when the post no
+// longer links to them, as they might still be linked from elsewhere: they
+// are marked as unused in the list of attachments of the post instead.
+func (s *AttachmentStore) RemoveOrphans(posts PostsService) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(s.root, AttachmentsDirName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("RemoveOrphans: %w", err)
+	}
+
+	var ids []string
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() {
+			continue
+		}
+		if exists, err := postExists(posts, id); err != nil {
+			return ids, fmt.Errorf("RemoveOrphans: %w", err)
+		} else if exists {
+			continue
+		}
+
+		if err := os.RemoveAll(s.dir(id)); err != nil {
+			return ids, fmt.Errorf("RemoveOrphans: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// postExists reports whether a post exists, either in the posts or in the
+// trash.
+func postExists(posts PostsService, id string) (bool, error) {
+	if _, err := posts.GetPost(id); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if _, err := posts.GetDeletedPost(id); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return false, nil
+}
+
+// removeAttachments removes the attachments of a purged post.
+func (app *App) removeAttachments(postID string) {
+	if app.attachments == nil {
+		return
+	}
+	if err := app.attachments.RemoveAll(postID); err != nil {
+		log.Printf("error: failed to remove the attachments of post %s: %v", postID, err)
+	}
+}
+
+// RemoveOrphanedAttachments removes the attachments of posts that no longer
+// exist, like those purged outside of the app. It goes through the
+// attachments of all posts, so it only runs in the background.
+func (app *App) RemoveOrphanedAttachments() {
+	if app.attachments == nil {
+		return
+	}
+
+	ids, err := app.attachments.RemoveOrphans(app.posts)
+	if err != nil {
+		log.Printf("error: failed to remove orphaned attachments: %v", err)
+	}
+	if len(ids) > 0 {
+		log.Printf("Removed the attachments of %d purged posts", len(ids))
+	}
+}
+
+// APIAttachment is the JSON representation of an uploaded attachment.
+type APIAttachment struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// Markdown embedding the attachment in a post
+	Markdown string `json:"markdown"`
+}
+
+// attachmentErrorStatus returns the HTTP status code of an error returned by
+// the attachment store.
+func attachmentErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrAttachmentTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// UploadAttachmentsHandler stores the files of a multipart form as
+// attachments of a post. JSON clients get the stored attachments, and
+// others are sent back to the post.
+func (app *App) UploadAttachmentsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		fail := func(status int, err error) {
+			log.Printf("error: UploadAttachmentsHandler: %v", err)
+			if wantsJSON(r) {
+				writeJSONError(w, status, err)
+			} else {
+				http.Error(w, fmt.Sprintf("%v", err), status)
+			}
+		}
+
+		if app.attachments == nil {
+			fail(http.StatusNotImplemented, errors.New("attachments are not supported"))
+			return
+		}
+		if _, err := app.posts.GetPost(postID); err != nil {
+			fail(apiErrorStatus(err), err)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadFiles*(app.attachments.maxSize+1<<10))
+		mr, err := r.MultipartReader()
+		if err != nil {
+			fail(http.StatusBadRequest, err)
+			return
+		}
+
+		var attachments []*APIAttachment
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				fail(attachmentErrorStatus(err), err)
+				return
+			}
+			if part.FormName() != "file" || part.FileName() == "" {
+				continue
+			}
+			if len(attachments) == maxUploadFiles {
+				fail(http.StatusRequestEntityTooLarge, fmt.Errorf("too many files, the limit is %d", maxUploadFiles))
+				return
+			}
+
+			a, err := app.attachments.Save(postID, part.FileName(), part)
+			if err != nil {
+				fail(attachmentErrorStatus(err), err)
+				return
+			}
+			attachments = append(attachments, &APIAttachment{
+				Name:        a.Name,
+				URL:         a.URL(),
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				Markdown:    a.Markdown(),
+			})
+		}
+		if len(attachments) == 0 {
+			fail(http.StatusBadRequest, errors.New("no files to upload"))
+			return
+		}
+
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusCreated, attachments)
+			return
+		}
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
+
+// AttachmentHandler serves an attachment. Only content types that are safe
+// to show in the browser are shown, everything else is downloaded.
+func (app *App) AttachmentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		name, _ := r.Context().Value("name").(string)
+
+		if app.attachments == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, a, err := app.attachments.Open(postID, name)
+		if err != nil {
+			log.Printf("error: AttachmentHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), attachmentErrorStatus(err))
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if !a.isInline() {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		}
+		http.ServeContent(w, r, a.Name, a.ModifiedTime, f)
+	}
+}
+
+func (app *App) DeleteAttachmentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		name, _ := r.Context().Value("name").(string)
+
+		if app.attachments == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := app.attachments.Delete(postID, name); err != nil {
+			log.Printf("error: DeleteAttachmentHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), attachmentErrorStatus(err))
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
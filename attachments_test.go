@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// A tiny, but valid PNG image
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+
+func newTestAttachmentStore(t *testing.T, maxSize int64) *AttachmentStore {
+	dir, err := os.MkdirTemp("", "")
+	is.New(t).NoErr(err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewAttachmentStore(dir, maxSize)
+}
+
+func TestAttachmentStore(t *testing.T) {
+	is := is.New(t)
+	store := newTestAttachmentStore(t, 100)
+
+	t.Run("names are cleaned up and unique", func(t *testing.T) {
+		is := is.New(t)
+		for _, tc := range []struct{ name, want string }{
+			{"screen shot.png", "screen-shot.png"},
+			{"screen shot.png", "screen-shot-1.png"},
+			{`..\..\etc/passwd`, "passwd.txt"}, // named after the content
+			{".env", "env.txt"},
+			{"", "attachment.txt"},
+		} {
+			a, err := store.Save("post", tc.name, strings.NewReader("text"))
+			is.NoErr(err)
+			is.Equal(a.Name, tc.want)
+		}
+
+		attachments, err := store.List("post")
+		is.NoErr(err)
+		is.Equal(len(attachments), 5)
+		is.Equal(attachments[0].Name, "attachment.txt")
+	})
+
+	t.Run("content types are detected", func(t *testing.T) {
+		is := is.New(t)
+		a, err := store.Save("post", "image", bytes.NewReader(testPNG))
+		is.NoErr(err)
+		is.Equal(a.Name, "image.png")
+		is.Equal(a.ContentType, "image/png")
+		is.True(a.IsImage())
+		is.Equal(a.Markdown(), "![image.png](/posts/post/attachments/image.png)")
+
+		a, err = store.Save("post", "fake.png", strings.NewReader("<html><script>alert(1)</script>"))
+		is.NoErr(err)
+		is.Equal(a.ContentType, "text/html; charset=utf-8")
+		is.True(!a.IsImage())
+		is.True(!a.isInline())
+		is.Equal(a.Markdown(), "[fake.png](/posts/post/attachments/fake.png)")
+	})
+
+	t.Run("size is limited", func(t *testing.T) {
+		is := is.New(t)
+		_, err := store.Save("post", "big", strings.NewReader(strings.Repeat("x", 101)))
+		is.True(errors.Is(err, ErrAttachmentTooLarge))
+		_, err = store.Save("post", "max", strings.NewReader(strings.Repeat("x", 100)))
+		is.NoErr(err)
+	})
+
+	t.Run("open and delete", func(t *testing.T) {
+		is := is.New(t)
+		f, a, err := store.Open("post", "screen-shot.png")
+		is.NoErr(err)
+		b, err := io.ReadAll(f)
+		f.Close()
+		is.NoErr(err)
+		is.Equal(string(b), "text")
+		is.Equal(a.Size, int64(4))
+
+		_, _, err = store.Open("post", "../post/screen-shot.png")
+		is.True(errors.Is(err, fs.ErrNotExist))
+		_, _, err = store.Open("..", "post")
+		is.True(errors.Is(err, fs.ErrNotExist))
+
+		is.NoErr(store.Delete("post", "screen-shot.png"))
+		_, _, err = store.Open("post", "screen-shot.png")
+		is.True(errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("orphans are removed", func(t *testing.T) {
+		is := is.New(t)
+		svc := NewMemoryPostsService()
+		live := &Post{Title: "live"}
+		is.NoErr(svc.CreatePost(live))
+		trashed := &Post{Title: "trashed"}
+		is.NoErr(svc.CreatePost(trashed))
+		is.NoErr(svc.DeletePost(trashed.ID))
+
+		for _, id := range []string{live.ID, trashed.ID} {
+			_, err := store.Save(id, "a.txt", strings.NewReader("a"))
+			is.NoErr(err)
+		}
+
+		ids, err := store.RemoveOrphans(svc)
+		is.NoErr(err)
+		is.Equal(ids, []string{"post"})
+		attachments, err := store.List(trashed.ID)
+		is.NoErr(err)
+		is.Equal(len(attachments), 1)
+
+		is.NoErr(svc.PurgePost(trashed.ID))
+		ids, err = store.RemoveOrphans(svc)
+		is.NoErr(err)
+		is.Equal(ids, []string{trashed.ID})
+		attachments, err = store.List(live.ID)
+		is.NoErr(err)
+		is.Equal(len(attachments), 1)
+	})
+
+	t.Run("remove all attachments of a post", func(t *testing.T) {
+		is := is.New(t)
+		_, err := store.Save("gone", "a.txt", strings.NewReader("a"))
+		is.NoErr(err)
+
+		is.NoErr(store.RemoveAll("gone"))
+		attachments, err := store.List("gone")
+		is.NoErr(err)
+		is.Equal(len(attachments), 0)
+		is.True(errors.Is(store.RemoveAll(".."), fs.ErrNotExist))
+	})
+}
+
+func TestAttachmentHandlers(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	app.attachments = newTestAttachmentStore(t, 1<<10)
+	post := &Post{Title: "a"}
+	is.NoErr(app.posts.CreatePost(post))
+
+	// upload sends files as a multipart form
+	upload := func(url string, wantJSON bool, files map[string]string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, content := range files {
+			fw, err := mw.CreateFormFile("file", name)
+			is.NoErr(err)
+			_, err = fw.Write([]byte(content))
+			is.NoErr(err)
+		}
+		is.NoErr(mw.Close())
+
+		r := httptest.NewRequest(http.MethodPost, url, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		if wantJSON {
+			r.Header.Set("Accept", JSONContentType)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+	uploadURL := "/posts/" + post.ID + "/attachments"
+
+	t.Run("upload", func(t *testing.T) {
+		is := is.New(t)
+		w := upload(uploadURL, true, map[string]string{"notes.txt": "hello"})
+		is.Equal(w.Code, http.StatusCreated)
+
+		var got []*APIAttachment
+		is.NoErr(json.NewDecoder(w.Body).Decode(&got))
+		is.Equal(len(got), 1)
+		is.Equal(got[0].Name, "notes.txt")
+		is.Equal(got[0].Markdown, "[notes.txt]("+uploadURL+"/notes.txt)")
+
+		w = upload(uploadURL, false, map[string]string{"page.html": "<html><body>hi</body></html>"})
+		is.Equal(w.Code, http.StatusSeeOther)
+	})
+
+	t.Run("upload errors", func(t *testing.T) {
+		is := is.New(t)
+		w := upload(uploadURL, true, map[string]string{"big.txt": strings.Repeat("x", 1<<10+1)})
+		is.Equal(w.Code, http.StatusRequestEntityTooLarge)
+		w = upload(uploadURL, true, nil)
+		is.Equal(w.Code, http.StatusBadRequest)
+		w = upload("/posts/nope/attachments", true, map[string]string{"a.txt": "a"})
+		is.Equal(w.Code, http.StatusNotFound)
+	})
+
+	t.Run("safe attachments are shown", func(t *testing.T) {
+		is := is.New(t)
+		w := get(uploadURL + "/notes.txt")
+		is.Equal(w.Code, http.StatusOK)
+		is.Equal(w.Body.String(), "hello")
+		is.Equal(w.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+		is.Equal(w.Header().Get("X-Content-Type-Options"), "nosniff")
+		is.Equal(w.Header().Get("Content-Disposition"), "")
+	})
+
+	t.Run("other attachments are downloaded", func(t *testing.T) {
+		is := is.New(t)
+		w := get(uploadURL + "/page.html")
+		is.Equal(w.Code, http.StatusOK)
+		is.Equal(w.Header().Get("Content-Disposition"), "attachment; filename=page.html")
+	})
+
+	t.Run("the post lists its attachments", func(t *testing.T) {
+		is := is.New(t)
+		post.Content = "[notes](" + uploadURL + "/notes.txt)"
+		is.NoErr(app.posts.UpdatePost(post))
+
+		body := get("/posts/" + post.ID).Body.String()
+		is.True(strings.Contains(body, `href="`+uploadURL+`/notes.txt"`))
+		is.True(strings.Contains(body, `href="`+uploadURL+`/page.html"`))
+		is.Equal(strings.Count(body, ">unused</span>"), 1) // page.html
+
+		body = get("/posts/" + post.ID + "?isEditing").Body.String()
+		is.True(strings.Contains(body, `data-upload-url="`+uploadURL+`"`))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		is := is.New(t)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, uploadURL+"/notes.txt/delete", nil))
+		is.Equal(w.Code, http.StatusSeeOther)
+		is.Equal(get(uploadURL+"/notes.txt").Code, http.StatusNotFound)
+	})
+
+	t.Run("purging a post removes its attachments", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(app.posts.DeletePost(post.ID))
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/trash/"+post.ID+"/purge", nil))
+		is.Equal(w.Code, http.StatusSeeOther)
+
+		attachments, err := app.attachments.List(post.ID)
+		is.NoErr(err)
+		is.Equal(len(attachments), 0)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// A PostConflict is an update of a post that was rejected because the post
+// was changed since the update was started, merged with that change.
+type PostConflict struct {
+	// The rejected update
+	Yours *Post
+	// The post as it is saved now
+	Theirs *Post
+	// The version both started from, if it is still known
+	Base *Revision
+
+	// Merged title. If changed on both sides, the title of the update wins.
+	Title         string
+	TitleConflict bool
+	// Tags added on either side, without those removed on either side
+	Tags []Tag
+	// Merged content, with conflicts between conflict markers
+	Content string
+	Chunks  []MergeChunk
+}
+
+// Conflicts returns the number of conflicting chunks of the content.
+func (c *PostConflict) Conflicts() int {
+	var n int
+	for _, ch := range c.Chunks {
+		if ch.Conflict {
+			n++
+		}
+	}
+	return n
+}
+
+// NewPostConflict merges a rejected update of a post with the post as it is
+// saved now. The version the update is based on is looked up by its
+// BaseVersion in the revisions of the post. If it is not found, everything
+// that differs is a conflict.
+func NewPostConflict(svc PostsService, yours *Post) (*PostConflict, error) {
+	theirs, err := svc.GetPost(yours.ID)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostConflict: %w", err)
+	}
+
+	base, err := findRevisionByVersion(svc, yours.ID, yours.BaseVersion)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostConflict: %w", err)
+	}
+
+	c := &PostConflict{Yours: yours, Theirs: theirs, Base: base}
+	var baseTitle, baseContent string
+	var baseTags []Tag
+	if base != nil {
+		baseTitle, baseContent, baseTags = base.Title, base.Content, base.Tags
+	}
+
+	c.Title, c.TitleConflict = merge3Value(baseTitle, yours.Title, theirs.Title)
+	c.Tags = mergeTags(baseTags, yours.Tags, theirs.Tags)
+	c.Chunks = Merge3(baseContent, yours.Content, theirs.Content)
+	c.Content = MergedText(c.Chunks)
+
+	return c, nil
+}
+
+// findRevisionByVersion returns the revision of a post with the given
+// version, or nil if there is none.
+func findRevisionByVersion(svc PostsService, postID, version string) (*Revision, error) {
+	if version == "" {
+		return nil, nil
+	}
+
+	revs, err := svc.ListRevisions(postID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if rev.Version() == version {
+			return rev, nil
+		}
+	}
+	return nil, nil
+}
+
+// merge3Value merges a single value changed on both sides. If changed
+// differently on both sides, ours wins and conflict is true.
+func merge3Value(base, ours, theirs string) (merged string, conflict bool) {
+	switch {
+	case ours == theirs, theirs == base:
+		return ours, false
+	case ours == base:
+		return theirs, false
+	}
+	return ours, true
+}
+
+// mergeTags keeps the tags of ours not removed in theirs, followed by the
+// tags added in theirs.
+func mergeTags(base, ours, theirs []Tag) []Tag {
+	var tags []Tag
+	for _, t := range ours {
+		if !slices.Contains(base, t) || slices.Contains(theirs, t) {
+			tags = append(tags, t)
+		}
+	}
+	for _, t := range theirs {
+		if !slices.Contains(base, t) && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// renderConflict shows a rejected update of a post merged with the saved
+// post, with a form to save the merged post.
+func (app *App) renderConflict(w http.ResponseWriter, yours *Post) {
+	c, err := NewPostConflict(app.posts, yours)
+	if err != nil {
+		log.Printf("error: renderConflict: %v", err)
+		http.Error(w, fmt.Sprintf("%v", err), 500)
+		return
+	}
+
+	locals := app.buildLocals(struct {
+		Conflict *PostConflict
+		// The merged post is saved over the current version
+		Version string
+	}{
+		Conflict: c,
+		Version:  c.Theirs.Version(),
+	})
+
+	w.WriteHeader(http.StatusConflict)
+	if err := app.templates.ExecuteTemplate(w, "conflict.html", locals); err != nil {
+		log.Printf("error: template: %v", err)
+	}
+}
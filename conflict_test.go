@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestPostConflicts(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	post := &Post{Title: "a", Content: "one\ntwo\nthree", Tags: []Tag{"x", "y"}}
+	is.NoErr(app.posts.CreatePost(post))
+	base := post.Version()
+
+	edit := func(version, title, tags, content string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"version": {version},
+			"title":   {title},
+			"tags":    {tags},
+			"content": {content},
+		}
+		r := httptest.NewRequest(http.MethodPost, "/posts/"+post.ID, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("updates of the current version are saved", func(t *testing.T) {
+		is := is.New(t)
+		w := edit(base, "a", "x,y,z", "one\ntwo\nthree\nfour")
+		is.Equal(w.Code, 303)
+
+		p, err := app.posts.GetPost(post.ID)
+		is.NoErr(err)
+		is.True(p.Version() != base)
+		is.Equal(p.BaseVersion, "")
+	})
+
+	t.Run("stale updates are rejected", func(t *testing.T) {
+		is := is.New(t)
+		p, err := app.posts.GetPost(post.ID)
+		is.NoErr(err)
+		p.BaseVersion = base
+		is.True(errors.Is(app.posts.UpdatePost(p), ErrConflict))
+	})
+
+	t.Run("stale edits are merged", func(t *testing.T) {
+		is := is.New(t)
+		w := edit(base, "b", "y", "ONE\ntwo\nthree")
+		is.Equal(w.Code, 409)
+		is.True(strings.Contains(w.Body.String(), "Conflicting edits"))
+
+		saved, err := app.posts.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(saved.Title, "a")
+
+		c, err := NewPostConflict(app.posts, &Post{
+			ID: post.ID, Title: "b", Content: "ONE\ntwo\nthree", Tags: []Tag{"y"}, BaseVersion: base,
+		})
+		is.NoErr(err)
+		is.True(c.Base != nil)
+		is.Equal(c.Title, "b")
+		is.True(!c.TitleConflict)
+		is.Equal(c.Tags, []Tag{"y", "z"})
+		is.Equal(c.Content, "ONE\ntwo\nthree\nfour")
+		is.Equal(c.Conflicts(), 0)
+
+		// The merged version is saved over the current one
+		is.True(strings.Contains(w.Body.String(), `name="version" value="`+saved.Version()+`"`))
+		is.Equal(edit(saved.Version(), c.Title, "y,z", c.Content).Code, 303)
+	})
+
+	t.Run("conflicting edits are marked", func(t *testing.T) {
+		is := is.New(t)
+		saved, err := app.posts.GetPost(post.ID)
+		is.NoErr(err)
+
+		c, err := NewPostConflict(app.posts, &Post{
+			ID: post.ID, Title: "c", Content: "uno\ntwo\nthree\nfour", BaseVersion: base,
+		})
+		is.NoErr(err)
+		is.True(c.TitleConflict)
+		is.Equal(c.Title, "c")
+		is.Equal(c.Conflicts(), 1)
+		is.True(strings.HasPrefix(c.Content, ConflictMarkerOurs+"\nuno\n"+ConflictMarkerSep+"\nONE\n"+ConflictMarkerTheirs))
+		is.Equal(c.Theirs.Version(), saved.Version())
+	})
+}
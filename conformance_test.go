@@ -0,0 +1,502 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestPostsServiceConformance(t *testing.T) {
+	tempDir := func(t *testing.T) string {
+		dir, err := os.MkdirTemp("", "posts")
+		is.New(t).NoErr(err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return dir
+	}
+
+	for _, backend := range []struct {
+		name       string
+		newService func(t *testing.T) PostsService
+	}{
+		{"file", func(t *testing.T) PostsService {
+			return NewPostsService(tempDir(t))
+		}},
+		{"markdown", func(t *testing.T) PostsService {
+			return NewPostsServiceWithFormat(tempDir(t), FormatMarkdown)
+		}},
+		{"git", func(t *testing.T) PostsService {
+			if _, err := exec.LookPath("git"); err != nil {
+				t.Skip("git is not installed")
+			}
+			svc, err := NewGitPostsService(tempDir(t), FormatJSON, "")
+			is.New(t).NoErr(err)
+			return svc
+		}},
+		{"sqlite", func(t *testing.T) PostsService {
+			svc, err := NewSQLitePostsService(path.Join(tempDir(t), SQLiteFileName))
+			is.New(t).NoErr(err)
+			t.Cleanup(func() { svc.Close() })
+			return svc
+		}},
+		{"memory", func(t *testing.T) PostsService {
+			return NewMemoryPostsService()
+		}},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			testPostsService(t, backend.newService)
+		})
+	}
+}
+
+// testPostsService checks that a PostsService implementation behaves like
+// all others. newService must return a new, empty service.
+func testPostsService(t *testing.T, newService func(t *testing.T) PostsService) {
+	// create adds posts to a service
+	create := func(t *testing.T, svc PostsService, posts ...*Post) {
+		is := is.New(t)
+		for _, p := range posts {
+			is.NoErr(svc.CreatePost(p))
+		}
+	}
+	ids := func(posts []*Post) []string {
+		ids := []string{}
+		for _, p := range posts {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	t.Run("create and get", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+
+		before := time.Now()
+		p := &Post{ID: "mine", Title: "foo", Content: "bar", Tags: []Tag{"a", "_dir:/x"}}
+		is.NoErr(svc.CreatePost(p))
+		is.True(p.ID != "" && p.ID != "mine") // IDs are assigned
+		is.True(!p.CreatedTime.Before(before))
+		is.True(p.ModifiedTime.Equal(p.CreatedTime))
+
+		got, err := svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(got.Title, "foo")
+		is.Equal(got.Content, "bar")
+		is.Equal(got.Tags, []Tag{"a", "_dir:/x"})
+		is.True(got.CreatedTime.Equal(p.CreatedTime))
+		is.True(got.ModifiedTime.Equal(p.ModifiedTime))
+		is.Equal(got.Version(), p.Version())
+
+		// Returned posts are copies
+		got.Title = "changed"
+		got.Tags[0] = "changed"
+		p.Title = "changed"
+		again, err := svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(again.Title, "foo")
+		is.Equal(again.Tags, []Tag{"a", "_dir:/x"})
+
+		other := &Post{Title: "other"}
+		is.NoErr(svc.CreatePost(other))
+		is.True(other.ID != p.ID)
+		posts, err = svc.ListPosts(&ListPostOptions{})
+		is.NoErr(err)
+		is.Equal(ids(posts), []string{p.ID, other.ID})
+	})
+
+	t.Run("tags are cleaned", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+
+		p := &Post{Title: "foo", Tags: []Tag{" a", "a", "", "b ", "  "}}
+		is.NoErr(svc.CreatePost(p))
+		is.Equal(p.Tags, []Tag{"a", "b"})
+		got, err := svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(got.Tags, []Tag{"a", "b"})
+
+		got.Tags = []Tag{"c", " c", "d"}
+		is.NoErr(svc.UpdatePost(got))
+		got, err = svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(got.Tags, []Tag{"c", "d"})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+
+		p := &Post{Title: "foo", Content: "one", Tags: []Tag{"a"}}
+		create(t, svc, p)
+		created := p.CreatedTime
+
+		p.Title = "bar"
+		p.Content = "two"
+		p.Tags = []Tag{"b"}
+		is.NoErr(svc.UpdatePost(p))
+		is.True(p.ModifiedTime.After(created))
+
+		got, err := svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(got.Title, "bar")
+		is.Equal(got.Content, "two")
+		is.Equal(got.Tags, []Tag{"b"})
+		is.True(got.CreatedTime.Equal(created))
+		is.True(got.ModifiedTime.Equal(p.ModifiedTime))
+
+		t.Run("keeps the previous version", func(t *testing.T) {
+			is := is.New(t)
+			revs, err := svc.ListRevisions(p.ID)
+			is.NoErr(err)
+			is.Equal(len(revs), 1)
+			is.Equal(revs[0].PostID, p.ID)
+			is.Equal(revs[0].Title, "foo")
+			is.Equal(revs[0].Content, "one")
+			is.Equal(revs[0].Tags, []Tag{"a"})
+			is.True(revs[0].ModifiedTime.Equal(created))
+
+			rev, err := svc.GetRevision(p.ID, revs[0].ID)
+			is.NoErr(err)
+			is.Equal(rev, revs[0])
+
+			got.Content = "three"
+			is.NoErr(svc.UpdatePost(got))
+			revs, err = svc.ListRevisions(p.ID)
+			is.NoErr(err)
+			is.Equal(len(revs), 2)
+			is.Equal(revs[0].Content, "two") // newest first
+		})
+
+		t.Run("of a stale version", func(t *testing.T) {
+			is := is.New(t)
+			cur, err := svc.GetPost(p.ID)
+			is.NoErr(err)
+
+			stale := &Post{ID: p.ID, Title: "stale", BaseVersion: p.Version()}
+			is.True(errors.Is(svc.UpdatePost(stale), ErrConflict))
+
+			cur.BaseVersion = cur.Version()
+			cur.Content = "four"
+			is.NoErr(svc.UpdatePost(cur))
+			is.Equal(cur.BaseVersion, "")
+		})
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		p := &Post{Title: "foo"}
+		create(t, svc, p)
+
+		_, err := svc.GetPost("nope")
+		is.True(errors.Is(err, fs.ErrNotExist)) // get
+		err = svc.UpdatePost(&Post{ID: "nope"})
+		is.True(errors.Is(err, fs.ErrNotExist)) // update
+		err = svc.DeletePost("nope")
+		is.True(errors.Is(err, fs.ErrNotExist)) // delete
+		err = svc.RestorePost(p.ID)
+		is.True(errors.Is(err, fs.ErrNotExist)) // restore a post that is not in the trash
+		err = svc.PurgePost(p.ID)
+		is.True(errors.Is(err, fs.ErrNotExist)) // purge a post that is not in the trash
+		_, err = svc.GetRevision(p.ID, "nope")
+		is.True(errors.Is(err, fs.ErrNotExist)) // get a revision
+		_, err = svc.ResolveLink("nope")
+		is.True(errors.Is(err, fs.ErrNotExist)) // resolve a link
+
+		revs, err := svc.ListRevisions("nope")
+		is.NoErr(err)
+		is.Equal(len(revs), 0)
+		backlinks, err := svc.ListBacklinks("nope")
+		is.NoErr(err)
+		is.Equal(len(backlinks), 0)
+
+		// The post is left alone
+		got, err := svc.GetPost(p.ID)
+		is.NoErr(err)
+		is.Equal(got.Version(), p.Version())
+	})
+
+	t.Run("list", func(t *testing.T) {
+		svc := newService(t)
+		a := &Post{Title: "Running nginx", Content: "The web servers run linux.", Tags: []Tag{"ops/web", "_dir:/work/ops"}}
+		b := &Post{Title: "linux", Content: "A kernel.", Tags: []Tag{"ops", "_pinned"}}
+		c := &Post{Title: "Grüne Bohnen", Content: "Milk", Tags: []Tag{"home", "_archived", "_dir:/home"}}
+		d := &Post{Title: "Ideas", Tags: []Tag{"home", "_draft", "_dir:/work"}}
+		create(t, svc, a, b, c, d)
+
+		for _, tc := range []struct {
+			name string
+			opts ListPostOptions
+			want []*Post
+		}{
+			{"all, pinned first", ListPostOptions{}, []*Post{b, a, c, d}},
+			{"search", ListPostOptions{SearchTerm: "server"}, []*Post{a}},
+			{"search a phrase", ListPostOptions{SearchTerm: `"web servers"`}, []*Post{a}},
+			{"search without results", ListPostOptions{SearchTerm: "windows"}, nil},
+			{"search ranks titles higher", ListPostOptions{SearchTerm: "linux", SortBy: SortByRelevance}, []*Post{b, a}},
+			{"tags and their descendants", ListPostOptions{TagsFilter: []string{"ops"}}, []*Post{b, a}},
+			{"all tags", ListPostOptions{TagsFilter: []string{"home", "_draft"}, TagMatch: TagMatchAll}, []*Post{d}},
+			{"none of the tags", ListPostOptions{TagsFilter: []string{"ops"}, TagMatch: TagMatchNone}, []*Post{c, d}},
+			{"excluded tags", ListPostOptions{ExcludeTags: []string{"home"}}, []*Post{b, a}},
+			{"title terms", ListPostOptions{TitleTerms: []string{"RUN", "nginx"}}, []*Post{a}},
+			{"title terms of any case", ListPostOptions{TitleTerms: []string{"GRÜNE"}}, []*Post{c}},
+			{"tags are not matched by prefix", ListPostOptions{TagsFilter: []string{"op"}}, nil},
+			{"folder", ListPostOptions{Dir: "/work/"}, []*Post{a, d}},
+			{"no archived posts or drafts", ListPostOptions{ExcludeArchived: true, ExcludeDrafts: true}, []*Post{b, a}},
+			{"created after", ListPostOptions{CreatedAfter: c.CreatedTime}, []*Post{c, d}},
+			{"modified before", ListPostOptions{ModifiedBefore: b.ModifiedTime}, []*Post{a}},
+			{"by title", ListPostOptions{SortBy: SortByTitle}, []*Post{b, c, d, a}},
+			{"descending", ListPostOptions{SortBy: SortByCreated, SortDescending: true}, []*Post{b, d, c, a}},
+			{"paged", ListPostOptions{Offset: 1, Limit: 2}, []*Post{a, c}},
+			{"offset only", ListPostOptions{Offset: 3}, []*Post{d}},
+			{"filtered and paged", ListPostOptions{TagsFilter: []string{"home"}, Offset: 1, Limit: 1}, []*Post{d}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				is := is.New(t)
+				posts, err := svc.ListPosts(&tc.opts)
+				is.NoErr(err)
+				is.Equal(ids(posts), ids(tc.want))
+			})
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		a := &Post{Title: "a", Tags: []Tag{"x", "y", "_dir:/a"}}
+		b := &Post{Title: "b", Tags: []Tag{"y"}}
+		create(t, svc, a, b)
+
+		tags, err := svc.ListTags(nil)
+		is.NoErr(err)
+		is.Equal(len(tags), 3)
+
+		tags, err = svc.ListTags(&ListTagOptions{IgnoreFunctional: true, SortBy: TagSortByCount})
+		is.NoErr(err)
+		is.Equal(len(tags), 2)
+		is.Equal(tags[0].Name, Tag("y"))
+		is.Equal(tags[0].Count, 2)
+		is.True(tags[0].LastUsed.Equal(b.ModifiedTime))
+		is.Equal(tags[1].Name, Tag("x"))
+	})
+
+	t.Run("folders", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		a := &Post{Title: "a", Tags: []Tag{"_dir:/foo"}}
+		b := &Post{Title: "b", Tags: []Tag{"_dir:/foo/bar", "_dir:/baz"}}
+		create(t, svc, a, b)
+
+		is.NoErr(svc.UpdateFolderSettings("/foo/", &FolderSettings{Icon: "bi-journal", Order: []string{b.ID}}))
+		is.True(svc.UpdateFolderSettings("foo", &FolderSettings{Icon: "journal"}) != nil) // invalid icon
+
+		s, err := svc.GetFolderSettings("foo")
+		is.NoErr(err)
+		is.Equal(s.Icon, "bi-journal")
+		s.Order[0] = "changed"
+		s, err = svc.GetFolderSettings("foo")
+		is.NoErr(err)
+		is.Equal(s.Order, []string{b.ID})
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(len(tree.Children), 2)
+		foo := tree.Find("foo")
+		is.Equal(ids(foo.Value), []string{a.ID})
+		is.Equal(foo.Icon(), "bi-journal")
+		is.Equal(ids(tree.Find("foo/bar").Value), []string{b.ID})
+		is.Equal(ids(tree.Find("baz").Value), []string{b.ID})
+
+		is.NoErr(svc.ChangeFolderSettings("", func(s *FolderSettings) (*FolderSettings, error) {
+			s.FolderOrder = []string{"foo", "baz"}
+			return s, nil
+		}))
+		is.True(svc.ChangeFolderSettings("", func(s *FolderSettings) (*FolderSettings, error) {
+			s.FolderOrder = []string{"foo/bar"}
+			return s, nil
+		}) != nil) // invalid folder name
+		tree, err = svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Children[0].Label, "foo")
+		is.Equal(tree.Children[1].Label, "baz")
+
+		is.NoErr(svc.ChangeFolderSettings("foo", func(s *FolderSettings) (*FolderSettings, error) {
+			is.Equal(s.Icon, "bi-journal")
+			s.Description = "Notes"
+			return s, nil
+		}))
+		s, err = svc.GetFolderSettings("foo")
+		is.NoErr(err)
+		is.Equal(s.Icon, "bi-journal")
+		is.Equal(s.Description, "Notes")
+
+		is.NoErr(svc.UpdateFolderSettings("foo", nil))
+		s, err = svc.GetFolderSettings("foo")
+		is.NoErr(err)
+		is.True(s.IsZero())
+	})
+
+	t.Run("links", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		a := &Post{Title: "Linux"}
+		create(t, svc, a)
+		b := &Post{Title: "b", Content: "See [[linux]] and [[" + a.ID + "|this]]."}
+		c := &Post{Title: "c", Content: "Nothing to see."}
+		create(t, svc, b, c)
+
+		p, err := svc.ResolveLink(" LINUX ")
+		is.NoErr(err)
+		is.Equal(p.ID, a.ID)
+		p, err = svc.ResolveLink(a.ID)
+		is.NoErr(err)
+		is.Equal(p.ID, a.ID)
+
+		backlinks, err := svc.ListBacklinks(a.ID)
+		is.NoErr(err)
+		is.Equal(ids(backlinks), []string{b.ID})
+
+		c.Content = "Also [[Linux]]."
+		is.NoErr(svc.UpdatePost(c))
+		backlinks, err = svc.ListBacklinks(a.ID)
+		is.NoErr(err)
+		want := []string{b.ID, c.ID}
+		sort.Strings(want) // ordered by ID
+		is.Equal(ids(backlinks), want)
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		a := &Post{Title: "a", Tags: []Tag{"x"}}
+		b := &Post{Title: "b", Content: "[[a]]"}
+		create(t, svc, a, b)
+		a.Content = "changed"
+		is.NoErr(svc.UpdatePost(a))
+
+		is.NoErr(svc.DeletePost(a.ID))
+		is.True(errors.Is(svc.DeletePost(a.ID), fs.ErrNotExist)) // already deleted
+		_, err := svc.GetPost(a.ID)
+		is.True(errors.Is(err, fs.ErrNotExist))
+
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(ids(posts), []string{b.ID})
+		tags, err := svc.ListTags(nil)
+		is.NoErr(err)
+		is.Equal(len(tags), 0)
+		_, err = svc.ResolveLink("a")
+		is.True(errors.Is(err, fs.ErrNotExist))
+
+		is.NoErr(svc.DeletePost(b.ID))
+		trashed, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(trashed), 2)
+		is.Equal(trashed[0].ID, b.ID) // most recently deleted first
+		is.Equal(trashed[1].Version(), a.Version())
+		is.True(!trashed[0].DeletedTime.Before(trashed[1].DeletedTime))
+		deleted, err := svc.GetDeletedPost(a.ID)
+		is.NoErr(err)
+		is.Equal(deleted.Version(), a.Version())
+		is.True(deleted.DeletedTime.Equal(trashed[1].DeletedTime))
+		_, err = svc.GetDeletedPost(b.ID + "x")
+		is.True(errors.Is(err, fs.ErrNotExist))
+
+		is.NoErr(svc.RestorePost(a.ID))
+		_, err = svc.GetDeletedPost(a.ID)
+		is.True(errors.Is(err, fs.ErrNotExist))
+		got, err := svc.GetPost(a.ID)
+		is.NoErr(err)
+		is.Equal(got.Version(), a.Version())
+		revs, err := svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 1)
+
+		// Nothing was deleted before then
+		purged, err := svc.PurgeDeletedPosts(trashed[1].DeletedTime)
+		is.NoErr(err)
+		is.Equal(len(purged), 0)
+
+		is.NoErr(svc.DeletePost(a.ID))
+		purged, err = svc.PurgeDeletedPosts(time.Now().Add(time.Minute))
+		is.NoErr(err)
+		is.Equal(len(purged), 2)
+		trashed, err = svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(trashed), 0)
+		revs, err = svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 0)
+		is.True(errors.Is(svc.RestorePost(a.ID), fs.ErrNotExist))
+	})
+
+	t.Run("concurrent writers", func(t *testing.T) {
+		is := is.New(t)
+		svc := newService(t)
+		p := &Post{Title: "shared"}
+		create(t, svc, p)
+
+		const n = 8
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			errs      []error
+			conflicts int
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(3)
+			go func(i int) {
+				defer wg.Done()
+				err := svc.CreatePost(&Post{Title: fmt.Sprintf("post %d", i), Tags: []Tag{"new"}})
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				err := svc.UpdatePost(&Post{ID: p.ID, Title: "shared", Content: fmt.Sprint(i)})
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				// All based on the same version, so only one wins
+				err := svc.UpdatePost(&Post{ID: p.ID, Title: "based", BaseVersion: p.Version()})
+				mu.Lock()
+				if errors.Is(err, ErrConflict) {
+					conflicts++
+				} else {
+					errs = append(errs, err)
+				}
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			is.NoErr(err)
+		}
+		is.True(conflicts >= n-1)
+
+		posts, err := svc.ListPosts(&ListPostOptions{TagsFilter: []string{"new"}})
+		is.NoErr(err)
+		is.Equal(len(posts), n)
+
+		revs, err := svc.ListRevisions(p.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), n+(n-conflicts))
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffInsert
+	DiffDelete
+)
+
+// A DiffLine is a single line of a line-level diff. OldLine and NewLine are
+// 1-based line numbers in the old and new text, or 0 if the line does not
+// exist on that side.
+type DiffLine struct {
+	Op      DiffOp
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+func (l DiffLine) IsEqual() bool  { return l.Op == DiffEqual }
+func (l DiffLine) IsInsert() bool { return l.Op == DiffInsert }
+func (l DiffLine) IsDelete() bool { return l.Op == DiffDelete }
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// DiffLines returns a line-level diff turning a into b, based on the longest
+// common subsequence of lines.
+func DiffLines(a, b string) []DiffLine {
+	return diffLines(splitLines(a), splitLines(b))
+}
+
+func diffLines(a, b []string) []DiffLine {
+	inA, inB := make([]bool, len(a)), make([]bool, len(b))
+	markLCS(a, b, inA, inB)
+
+	var (
+		out    []DiffLine
+		oldNum int
+		newNum int
+	)
+	for oldNum < len(a) || newNum < len(b) {
+		switch {
+		case oldNum < len(a) && !inA[oldNum]:
+			oldNum++
+			out = append(out, DiffLine{Op: DiffDelete, Text: a[oldNum-1], OldLine: oldNum})
+		case newNum < len(b) && !inB[newNum]:
+			newNum++
+			out = append(out, DiffLine{Op: DiffInsert, Text: b[newNum-1], NewLine: newNum})
+		default:
+			oldNum++
+			newNum++
+			out = append(out, DiffLine{Op: DiffEqual, Text: a[oldNum-1], OldLine: oldNum, NewLine: newNum})
+		}
+	}
+	return out
+}
+
+// markLCS marks the lines of a and b that are part of a longest common
+// subsequence of both in inA and inB. It uses the linear space variant of
+// Myers' diff algorithm, which takes time in proportion to the size of the
+// texts times the number of differences.
+func markLCS(a, b []string, inA, inB []bool) {
+	// Common prefix and suffix are part of the LCS
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		inA[0], inB[0] = true, true
+		a, b, inA, inB = a[1:], b[1:], inA[1:], inB[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		inA[len(a)-1], inB[len(b)-1] = true, true
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+
+	// Without common prefix and suffix, there are at least two differences,
+	// which are split between both sides of the middle snake.
+	x, y, u, v := middleSnake(a, b)
+	for i := x; i < u; i++ {
+		inA[i], inB[y+i-x] = true, true
+	}
+	markLCS(a[:x], b[:y], inA[:x], inB[:y])
+	markLCS(a[u:], b[v:], inA[u:], inB[v:])
+}
+
+// middleSnake returns the diagonal run of equal lines, from a[x], b[y] to
+// a[u], b[v], in the middle of a shortest edit script turning a into b. Edit
+// paths are followed from both ends at once until they overlap.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	maxD := (n + m + 1) / 2
+
+	// Furthest x on each diagonal k = x - y, from the start (vf) and the end
+	// (vb, counting from the end of both texts), offset by maxD+1.
+	off := maxD + 1
+	vf, vb := make([]int, 2*maxD+3), make([]int, 2*maxD+3)
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			x := vf[off+k-1] + 1
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			vf[off+k] = x
+			if kb := delta - k; delta%2 != 0 && kb >= -(d-1) && kb <= d-1 && x+vb[off+kb] >= n {
+				return x0, y0, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			x := vb[off+k-1] + 1
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x, y = x+1, y+1
+			}
+			vb[off+k] = x
+			if kf := delta - k; delta%2 == 0 && kf >= -d && kf <= d && x+vf[off+kf] >= n {
+				return n - x, m - y, n - x0, m - y0
+			}
+		}
+	}
+	// Not reached, the paths meet after at most maxD differences
+	return 0, 0, 0, 0
+}
+
+// A MergeChunk is a run of lines of a three-way merge. Lines of chunks
+// without conflicts are merged already. Conflicting chunks have the lines of
+// each side instead.
+type MergeChunk struct {
+	Lines    []string
+	Conflict bool
+	Base     []string
+	Ours     []string
+	Theirs   []string
+}
+
+// Markers around the sides of a conflict in merged text
+const (
+	ConflictMarkerOurs   = "<<<<<<< yours"
+	ConflictMarkerSep    = "======="
+	ConflictMarkerTheirs = ">>>>>>> saved"
+)
+
+// Merge3 merges the changes made to base in ours and theirs, line by line.
+// Changes made on one side only are taken over, and lines changed
+// differently on both sides are conflicts.
+func Merge3(base, ours, theirs string) []MergeChunk {
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	mo, mt := lineMatches(b, o), lineMatches(b, t)
+
+	var chunks []MergeChunk
+	add := func(c MergeChunk) {
+		if !c.Conflict && len(c.Lines) == 0 {
+			return
+		}
+		// Join adjacent merged runs
+		if n := len(chunks); n > 0 && !c.Conflict && !chunks[n-1].Conflict {
+			chunks[n-1].Lines = append(chunks[n-1].Lines, c.Lines...)
+			return
+		}
+		chunks = append(chunks, c)
+	}
+
+	i, j, k := 0, 0, 0
+	for i < len(b) || j < len(o) || k < len(t) {
+		// Lines unchanged on both sides
+		var stable []string
+		for i < len(b) && mo[i] == j && mt[i] == k {
+			stable = append(stable, b[i])
+			i, j, k = i+1, j+1, k+1
+		}
+		add(MergeChunk{Lines: stable})
+
+		// The changes up to the next line unchanged on both sides
+		ni, nj, nk := i, len(o), len(t)
+		for ; ni < len(b); ni++ {
+			if mo[ni] >= 0 && mt[ni] >= 0 {
+				nj, nk = mo[ni], mt[ni]
+				break
+			}
+		}
+		cb, co, ct := b[i:ni], o[j:nj], t[k:nk]
+		i, j, k = ni, nj, nk
+
+		switch {
+		case slices.Equal(co, cb):
+			add(MergeChunk{Lines: ct})
+		case slices.Equal(ct, cb), slices.Equal(co, ct):
+			add(MergeChunk{Lines: co})
+		default:
+			add(MergeChunk{Conflict: true, Base: cb, Ours: co, Theirs: ct})
+		}
+	}
+	return chunks
+}
+
+// lineMatches returns the index of each line of a in b, or -1 for lines of a
+// not in the longest common subsequence.
+func lineMatches(a, b []string) []int {
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+	for _, l := range diffLines(a, b) {
+		if l.IsEqual() {
+			m[l.OldLine-1] = l.NewLine - 1
+		}
+	}
+	return m
+}
+
+// MergedText returns the text of a three-way merge, with both sides of each
+// conflict between conflict markers.
+func MergedText(chunks []MergeChunk) string {
+	var lines []string
+	for _, c := range chunks {
+		if !c.Conflict {
+			lines = append(lines, c.Lines...)
+			continue
+		}
+		lines = append(lines, ConflictMarkerOurs)
+		lines = append(lines, c.Ours...)
+		lines = append(lines, ConflictMarkerSep)
+		lines = append(lines, c.Theirs...)
+		lines = append(lines, ConflictMarkerTheirs)
+	}
+	return strings.Join(lines, "\n")
+}
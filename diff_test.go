@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestDiffLines(t *testing.T) {
+	t.Run("equal texts", func(t *testing.T) {
+		is := is.New(t)
+		diff := DiffLines("a\nb\n", "a\nb")
+		is.Equal(len(diff), 2)
+		is.True(diff[0].IsEqual())
+		is.True(diff[1].IsEqual())
+	})
+
+	t.Run("empty texts", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(len(DiffLines("", "")), 0)
+	})
+
+	t.Run("insertions and deletions", func(t *testing.T) {
+		is := is.New(t)
+		diff := DiffLines("a\nb\nc\nd", "a\nx\nc\nd\ne")
+
+		is.Equal(diff, []DiffLine{
+			{Op: DiffEqual, Text: "a", OldLine: 1, NewLine: 1},
+			{Op: DiffDelete, Text: "b", OldLine: 2},
+			{Op: DiffInsert, Text: "x", NewLine: 2},
+			{Op: DiffEqual, Text: "c", OldLine: 3, NewLine: 3},
+			{Op: DiffEqual, Text: "d", OldLine: 4, NewLine: 4},
+			{Op: DiffInsert, Text: "e", NewLine: 5},
+		})
+	})
+
+	t.Run("everything replaced", func(t *testing.T) {
+		is := is.New(t)
+		diff := DiffLines("a\nb", "c")
+		is.Equal(len(diff), 3)
+		is.True(diff[0].IsDelete())
+		is.True(diff[1].IsDelete())
+		is.True(diff[2].IsInsert())
+	})
+
+	t.Run("longest common subsequence", func(t *testing.T) {
+		is := is.New(t)
+		rnd := rand.New(rand.NewSource(1))
+		lines := func() []string {
+			l := make([]string, rnd.Intn(12))
+			for i := range l {
+				l[i] = string(rune('a' + rnd.Intn(4)))
+			}
+			return l
+		}
+
+		for n := 0; n < 1000; n++ {
+			a, b := lines(), lines()
+			var oldLines, newLines []string
+			var equal int
+			for _, l := range diffLines(a, b) {
+				if !l.IsInsert() {
+					oldLines = append(oldLines, l.Text)
+				}
+				if !l.IsDelete() {
+					newLines = append(newLines, l.Text)
+				}
+				if l.IsEqual() {
+					equal++
+				}
+			}
+			is.Equal(strings.Join(oldLines, ""), strings.Join(a, ""))
+			is.Equal(strings.Join(newLines, ""), strings.Join(b, ""))
+			is.Equal(equal, lcsLength(a, b))
+		}
+	})
+
+	t.Run("long texts", func(t *testing.T) {
+		is := is.New(t)
+		a := make([]string, 200000)
+		for i := range a {
+			a[i] = strconv.Itoa(i)
+		}
+		b := slices.Clone(a)
+		b[1000], b[100000] = "x", "y"
+		b = slices.Insert(b, 150000, "z")
+
+		var changes int
+		for _, l := range diffLines(a, b) {
+			if !l.IsEqual() {
+				changes++
+			}
+		}
+		is.Equal(changes, 5)
+	})
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+func TestMerge3(t *testing.T) {
+	t.Run("changes on one side", func(t *testing.T) {
+		is := is.New(t)
+		chunks := Merge3("a\nb\nc", "a\nB\nc", "a\nb\nc\nd")
+		is.Equal(chunks, []MergeChunk{{Lines: []string{"a", "B", "c", "d"}}})
+	})
+
+	t.Run("same change on both sides", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(MergedText(Merge3("a\nb", "a\nx", "a\nx")), "a\nx")
+	})
+
+	t.Run("conflicting changes", func(t *testing.T) {
+		is := is.New(t)
+		chunks := Merge3("a\nb\nc\nd", "a\nx\nc\nd", "y\na\ny\nc")
+		is.Equal(chunks, []MergeChunk{
+			{Lines: []string{"y", "a"}},
+			{Conflict: true, Base: []string{"b"}, Ours: []string{"x"}, Theirs: []string{"y"}},
+			{Lines: []string{"c"}},
+		})
+		is.Equal(MergedText(chunks), "y\na\n<<<<<<< yours\nx\n=======\ny\n>>>>>>> saved\nc")
+	})
+
+	t.Run("unknown base", func(t *testing.T) {
+		is := is.New(t)
+		chunks := Merge3("", "a", "b")
+		is.Equal(len(chunks), 1)
+		is.True(chunks[0].Conflict)
+	})
+}
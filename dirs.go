@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// cleanDir returns a folder path without leading, trailing or repeated
+// separators, like `foo/bar`. The root folder is empty.
+func cleanDir(dir string) string {
+	var segs []string
+	for _, s := range strings.Split(dir, TagPathSeparator) {
+		if s = strings.TrimSpace(s); s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return strings.Join(segs, TagPathSeparator)
+}
+
+// DirTag returns the functional tag placing a post in a folder.
+func DirTag(dir string) Tag {
+	return Tag(DirTagPrefix + TagPathSeparator + cleanDir(dir))
+}
+
+// dirURL returns the URL of the page of a folder.
+func dirURL(dir string) string {
+	u := url.URL{Path: "/dirs/" + cleanDir(dir)}
+	return u.String()
+}
+
+// A Breadcrumb is a folder on the path to a post or another folder.
+type Breadcrumb struct {
+	Label string
+	Path  string
+}
+
+// Breadcrumbs returns the folders from the top-level folder down to dir.
+func Breadcrumbs(dir string) []Breadcrumb {
+	dir = cleanDir(dir)
+	if dir == "" {
+		return nil
+	}
+
+	segs := strings.Split(dir, TagPathSeparator)
+	crumbs := make([]Breadcrumb, len(segs))
+	for i, s := range segs {
+		crumbs[i] = Breadcrumb{
+			Label: s,
+			Path:  strings.Join(segs[:i+1], TagPathSeparator),
+		}
+	}
+	return crumbs
+}
+
+// MovePost places a post in a single folder, removing it from any other
+// folders. An empty folder removes the post from all folders.
+func MovePost(svc PostsService, postID, dir string) (*Post, error) {
+	post, err := svc.GetPost(postID)
+	if err != nil {
+		return nil, fmt.Errorf("MovePost: %w", err)
+	}
+
+	dir = cleanDir(dir)
+	if err := validateTagName(DirTag(dir)); err != nil {
+		return nil, fmt.Errorf("MovePost: %w", err)
+	}
+
+	post, err = changePost(svc, post, func(post *Post) (bool, error) {
+		var tags []Tag
+		for _, t := range post.Tags {
+			if !strings.HasPrefix(string(t), DirTagPrefix) {
+				tags = append(tags, t)
+			}
+		}
+		if dir != "" {
+			tags = append(tags, DirTag(dir))
+		}
+		post.Tags = tags
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("MovePost: %w", err)
+	}
+	return post, nil
+}
+
+// MovePostFrom moves a post from one of its folders to another, keeping it
+// in any other folders. An empty target folder removes the post from the
+// folder.
+func MovePostFrom(svc PostsService, postID, from, to string) (*Post, error) {
+	post, err := svc.GetPost(postID)
+	if err != nil {
+		return nil, fmt.Errorf("MovePostFrom: %w", err)
+	}
+
+	from, to = cleanDir(from), cleanDir(to)
+	if err := validateTagName(DirTag(to)); err != nil {
+		return nil, fmt.Errorf("MovePostFrom: %w", err)
+	}
+
+	post, err = changePost(svc, post, func(post *Post) (bool, error) {
+		var tags []Tag
+		var found bool
+		for _, t := range post.Tags {
+			if dir, ok := strings.CutPrefix(string(t), DirTagPrefix); ok && cleanDir(dir) == from {
+				found = true
+				if to != "" {
+					tags = append(tags, DirTag(to))
+				}
+				continue
+			}
+			tags = append(tags, t)
+		}
+		if !found {
+			return false, fmt.Errorf("post %s is not in folder %q", postID, from)
+		}
+		if from == to {
+			return false, nil
+		}
+		post.Tags = tags
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("MovePostFrom: %w", err)
+	}
+	return post, nil
+}
+
+// MoveDir renames or moves a folder and its sub-folders by rewriting the
+// folder tags of all posts in them. Folder settings are moved along, and
+// merged with those of folders that already exist at the new path. It
+// returns the updated posts.
+func MoveDir(svc PostsService, from, to string) ([]*Post, error) {
+	from, to = cleanDir(from), cleanDir(to)
+	switch {
+	case from == "":
+		return nil, errors.New("MoveDir: the root folder can not be moved")
+	case to == "":
+		return nil, errors.New("MoveDir: folder name is required")
+	case to == from:
+		return nil, nil
+	case Tag(to).IsWithin(from):
+		return nil, fmt.Errorf("MoveDir: can not move %q into itself", from)
+	}
+	if err := validateTagName(DirTag(to)); err != nil {
+		return nil, fmt.Errorf("MoveDir: %w", err)
+	}
+
+	posts, err := svc.ListPosts(&ListPostOptions{Dir: from, SortBy: SortByTitle})
+	if err != nil {
+		return nil, fmt.Errorf("MoveDir: %w", err)
+	}
+
+	// Old folder path -> new folder path
+	moved := map[string]string{from: to}
+
+	var updated []*Post
+	for _, p := range posts {
+		var changed bool
+		p, err := changePost(svc, p, func(p *Post) (bool, error) {
+			changed = false
+			for i, t := range p.Tags {
+				dir, ok := strings.CutPrefix(string(t), DirTagPrefix)
+				if !ok {
+					continue
+				}
+				if dir = cleanDir(dir); Tag(dir).IsWithin(from) {
+					moved[dir] = to + dir[len(from):]
+					p.Tags[i] = DirTag(moved[dir])
+					changed = true
+				}
+			}
+			return changed, nil
+		})
+		if err != nil {
+			return updated, fmt.Errorf("MoveDir: %w", err)
+		}
+		if changed {
+			updated = append(updated, p)
+		}
+	}
+
+	for old, dir := range moved {
+		s, err := svc.GetFolderSettings(old)
+		if err != nil {
+			return updated, fmt.Errorf("MoveDir: %w", err)
+		}
+		if s.IsZero() {
+			continue
+		}
+		err = svc.ChangeFolderSettings(dir, func(existing *FolderSettings) (*FolderSettings, error) {
+			return existing.merge(s), nil
+		})
+		if err != nil {
+			return updated, fmt.Errorf("MoveDir: %w", err)
+		}
+		if err := svc.UpdateFolderSettings(old, nil); err != nil {
+			return updated, fmt.Errorf("MoveDir: %w", err)
+		}
+	}
+
+	// A folder renamed in place keeps its position among its siblings
+	fromParent, fromName := path.Split(from)
+	toParent, toName := path.Split(to)
+	if fromParent == toParent {
+		err := svc.ChangeFolderSettings(fromParent, func(s *FolderSettings) (*FolderSettings, error) {
+			if i := slices.Index(s.FolderOrder, fromName); i >= 0 {
+				s.FolderOrder[i] = toName
+			}
+			return s, nil
+		})
+		if err != nil {
+			return updated, fmt.Errorf("MoveDir: %w", err)
+		}
+	}
+
+	return updated, nil
+}
+
+// DirHandler shows the posts and sub-folders of a folder.
+func (app *App) DirHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, _ := r.Context().Value("path").(string)
+		dir = cleanDir(dir)
+
+		tree, err := app.posts.GetPostsFolderTree()
+		if err != nil {
+			log.Printf("error: DirHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 500)
+			return
+		}
+
+		node := tree.Find(dir)
+		if node == nil {
+			http.Error(w, fmt.Sprintf("folder %q: %v", dir, fs.ErrNotExist), 404)
+			return
+		}
+
+		// Archived posts are only shown when asked for
+		archived, err := boolFormValue(r, "archived")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+		if !archived {
+			node = node.Filter(notArchived)
+		}
+
+		settings := node.Settings
+		if settings == nil {
+			settings = new(FolderSettings)
+		}
+
+		var index *Post
+		var indexHTML template.HTML
+		if settings.IndexPostID != "" {
+			if index, err = app.posts.GetPost(settings.IndexPostID); err != nil {
+				log.Printf("error: DirHandler: index post: %v", err)
+			} else {
+				indexHTML = RenderContentHTML(index.Content, app.posts)
+			}
+		}
+
+		locals := app.buildLocals(struct {
+			Dir         *Node
+			Settings    *FolderSettings
+			Breadcrumbs []Breadcrumb
+			IndexPost   *Post
+			IndexHTML   template.HTML
+			SortKeys    []PostSortKey
+		}{
+			Dir:         node,
+			Settings:    settings,
+			Breadcrumbs: Breadcrumbs(dir),
+			IndexPost:   index,
+			IndexHTML:   indexHTML,
+			SortKeys:    []PostSortKey{SortByCreated, SortByModified, SortByTitle},
+		})
+
+		if err := app.templates.ExecuteTemplate(w, "dir.html", locals); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
+
+// Actions of the folder page
+const (
+	DirActionMove     = "move"
+	DirActionSettings = "settings"
+)
+
+// folderSettingsFromRequest returns the folder settings of the settings
+// form. The manual orders are not part of the form.
+func folderSettingsFromRequest(r *http.Request) (*FolderSettings, error) {
+	sortBy, err := ParsePostSortKey(r.PostForm.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FolderSettings{
+		IndexPostID: strings.TrimSpace(r.PostForm.Get("index")),
+		Description: strings.TrimSpace(r.PostForm.Get("description")),
+		Icon:        strings.TrimSpace(r.PostForm.Get("icon")),
+		SortBy:      sortBy,
+	}
+	return s, s.validate()
+}
+
+// ChangeDirHandler renames or moves a folder, or changes its settings.
+func (app *App) ChangeDirHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, _ := r.Context().Value("path").(string)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+
+		var err error
+		switch action := r.PostForm.Get("action"); action {
+		case DirActionMove:
+			to := r.PostForm.Get("to")
+			_, err = MoveDir(app.posts, dir, to)
+			// Show the folder at its new path
+			dir = to
+		case DirActionSettings:
+			var s *FolderSettings
+			if s, err = folderSettingsFromRequest(r); err != nil {
+				break
+			}
+			if s.IndexPostID != "" {
+				if _, err = app.posts.GetPost(s.IndexPostID); err != nil {
+					break
+				}
+			}
+			err = app.posts.ChangeFolderSettings(dir, func(current *FolderSettings) (*FolderSettings, error) {
+				s.Order, s.FolderOrder = current.Order, current.FolderOrder
+				return s, nil
+			})
+		default:
+			err = fmt.Errorf("unknown action %q", action)
+		}
+
+		if err != nil {
+			log.Printf("error: ChangeDirHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+
+		http.Redirect(w, r, dirURL(dir), http.StatusSeeOther)
+	}
+}
+
+// MovePostHandler moves a post to another folder.
+func (app *App) MovePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		if _, err := MovePost(app.posts, postID, r.FormValue("dir")); err != nil {
+			log.Printf("error: MovePostHandler: %v", err)
+			code := 400
+			if errors.Is(err, fs.ErrNotExist) {
+				code = 404
+			}
+			http.Error(w, fmt.Sprintf("%v", err), code)
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
+
+// TreeMoveHandler moves a post or a folder dropped on another folder in the
+// sidebar tree. A dragged post is given with its ID in `post` and the folder
+// it was dragged from in `from`, and the new order of the posts in the target
+// folder in `order`. A dragged folder is given with its path in `dir`, and
+// the new order of the sub-folders of the target folder, by name, in `order`.
+func (app *App) TreeMoveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+		to := cleanDir(r.PostForm.Get("to"))
+
+		var err error
+		switch postID, dir := r.PostForm.Get("post"), r.PostForm.Get("dir"); {
+		case postID != "":
+			if !plainIDPattern.MatchString(postID) {
+				err = fmt.Errorf("post %q: %w", postID, fs.ErrNotExist)
+				break
+			}
+			_, err = MovePostFrom(app.posts, postID, r.PostForm.Get("from"), to)
+			if err == nil && r.PostForm.Has("order") {
+				err = SetFolderOrder(app.posts, to, r.PostForm["order"])
+			}
+		case dir != "":
+			_, err = MoveDir(app.posts, dir, path.Join(to, path.Base(cleanDir(dir))))
+			if err == nil && r.PostForm.Has("order") {
+				err = SetSubfolderOrder(app.posts, to, r.PostForm["order"])
+			}
+		default:
+			err = errors.New("nothing to move")
+		}
+
+		if err != nil {
+			log.Printf("error: TreeMoveHandler: %v", err)
+			code := 400
+			if errors.Is(err, fs.ErrNotExist) {
+				code = 404
+			}
+			http.Error(w, fmt.Sprintf("%v", err), code)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestBreadcrumbs(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(Breadcrumbs("/"), []Breadcrumb(nil))
+	is.Equal(Breadcrumbs("/work//ops/ "), []Breadcrumb{
+		{Label: "work", Path: "work"},
+		{Label: "ops", Path: "work/ops"},
+	})
+}
+
+func TestMoveDirs(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	a := &Post{Title: "a", Tags: []Tag{"linux", "_dir:/work/ops"}}
+	is.NoErr(svc.CreatePost(a))
+	b := &Post{Title: "b", Tags: []Tag{"_dir:/work/ops/web", "_dir:/home"}}
+	is.NoErr(svc.CreatePost(b))
+	c := &Post{Title: "c", Tags: []Tag{"_dir:/work/opsec"}}
+	is.NoErr(svc.CreatePost(c))
+
+	tags := func(id string) []Tag {
+		p, err := svc.GetPost(id)
+		is.NoErr(err)
+		return p.Tags
+	}
+
+	t.Run("move a post", func(t *testing.T) {
+		is := is.New(t)
+		_, err := MovePost(svc, b.ID, "/work/ops/web/")
+		is.NoErr(err)
+		is.Equal(tags(b.ID), []Tag{"_dir:/work/ops/web"})
+	})
+
+	t.Run("move a post out of all folders", func(t *testing.T) {
+		is := is.New(t)
+		_, err := MovePost(svc, c.ID, "")
+		is.NoErr(err)
+		is.Equal(len(tags(c.ID)), 0)
+
+		_, err = MovePost(svc, c.ID, "work/opsec")
+		is.NoErr(err)
+	})
+
+	t.Run("move a post from one of its folders", func(t *testing.T) {
+		is := is.New(t)
+		d := &Post{Title: "d", Tags: []Tag{"_dir:/home", "_dir:/work"}}
+		is.NoErr(svc.CreatePost(d))
+
+		_, err := MovePostFrom(svc, d.ID, "home/", "/work/ops")
+		is.NoErr(err)
+		is.Equal(tags(d.ID), []Tag{"_dir:/work/ops", "_dir:/work"})
+
+		_, err = MovePostFrom(svc, d.ID, "home", "work")
+		is.True(err != nil) // not in that folder
+
+		is.NoErr(svc.DeletePost(d.ID))
+	})
+
+	t.Run("move a folder", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := MoveDir(svc, "work/ops", "ops")
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.Equal(tags(a.ID), []Tag{"linux", "_dir:/ops"})
+		is.Equal(tags(b.ID), []Tag{"_dir:/ops/web"})
+		is.Equal(tags(c.ID), []Tag{"_dir:/work/opsec"})
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Find("ops/web").Value[0].ID, b.ID)
+		is.Equal(tree.Find("work/ops"), nil)
+	})
+
+	t.Run("invalid moves", func(t *testing.T) {
+		is := is.New(t)
+		for _, tc := range [][2]string{
+			{"", "foo"},
+			{"ops", " "},
+			{"ops", "ops/web/foo"},
+			{"ops", "a,b"},
+			{"ops", "ops/web"},
+		} {
+			_, err := MoveDir(svc, tc[0], tc[1])
+			is.True(err != nil)
+		}
+
+		_, err := MovePost(svc, "nope", "foo")
+		is.True(err != nil)
+	})
+}
+
+func TestDirHandlers(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	post := &Post{Title: "a", Tags: []Tag{"_dir:/foo/bar"}}
+	is.NoErr(app.posts.CreatePost(post))
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("folder pages", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodGet, "/dirs/foo", "")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `href="/dirs/foo/bar"`))
+
+		is.Equal(do(http.MethodGet, "/dirs", "").Code, 200)
+		is.Equal(do(http.MethodGet, "/dirs/nope", "").Code, 404)
+	})
+
+	t.Run("post breadcrumbs", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodGet, "/posts/"+post.ID, "")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `<a href="/dirs/foo/bar">bar</a>`))
+	})
+
+	t.Run("move a folder", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodPost, "/dirs/foo", "action=move&to=baz qux")
+		is.Equal(w.Code, 303)
+		is.Equal(w.Header().Get("Location"), "/dirs/baz%20qux")
+
+		is.Equal(do(http.MethodGet, "/dirs/baz%20qux/bar", "").Code, 200)
+		is.Equal(do(http.MethodPost, "/dirs/baz%20qux", "action=move&to=").Code, 400)
+		is.Equal(do(http.MethodPost, "/dirs/baz%20qux", "to=foo").Code, 400)
+	})
+
+	t.Run("drag and drop", func(t *testing.T) {
+		is := is.New(t)
+		other := &Post{Title: "b", Tags: []Tag{"_dir:/x"}}
+		is.NoErr(app.posts.CreatePost(other))
+
+		w := do(http.MethodPost, "/tree/move", "post="+other.ID+"&from=x&to=baz+qux/bar&order="+other.ID+"&order="+post.ID)
+		is.Equal(w.Code, 204)
+
+		tree, err := app.posts.GetPostsFolderTree()
+		is.NoErr(err)
+		bar := tree.Find("baz qux/bar")
+		is.Equal(len(bar.Value), 2)
+		is.Equal(bar.Value[0].ID, other.ID)
+
+		w = do(http.MethodPost, "/tree/move", "dir=baz+qux/bar&to=")
+		is.Equal(w.Code, 204)
+		tree, err = app.posts.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Find("bar").Value[0].ID, other.ID)
+
+		// Folders dropped before another folder are kept in that order
+		third := &Post{Title: "c", Tags: []Tag{"_dir:/a"}}
+		is.NoErr(app.posts.CreatePost(third))
+		w = do(http.MethodPost, "/tree/move", "dir=bar&to=&order=bar&order=a")
+		is.Equal(w.Code, 204)
+		tree, err = app.posts.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Children[0].Label, "bar")
+		is.Equal(tree.Children[1].Label, "a")
+		is.Equal(do(http.MethodPost, "/tree/move", "dir=bar&to=&order=x/y").Code, 400)
+		is.NoErr(app.posts.DeletePost(third.ID))
+
+		is.Equal(do(http.MethodPost, "/tree/move", "dir=bar&to=bar/x").Code, 400)
+		is.Equal(do(http.MethodPost, "/tree/move", "post=nope&from=bar&to=x").Code, 404)
+		is.Equal(do(http.MethodPost, "/tree/move", "post=..%2Fnope&from=bar&to=x").Code, 404)
+		is.Equal(do(http.MethodPost, "/tree/move", "to=x").Code, 400)
+
+		is.NoErr(app.posts.DeletePost(other.ID))
+	})
+
+	t.Run("folder settings", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodPost, "/dirs/bar", "action=settings&description=Notes&icon=bi-journal&sort=title&index="+post.ID)
+		is.Equal(w.Code, 303)
+		is.Equal(w.Header().Get("Location"), "/dirs/bar")
+
+		s, err := app.posts.GetFolderSettings("bar")
+		is.NoErr(err)
+		is.Equal(s.Description, "Notes")
+		is.Equal(s.SortBy, SortByTitle)
+
+		w = do(http.MethodGet, "/dirs/bar", "")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `<i class="bi-journal"></i>`))
+		is.True(strings.Contains(w.Body.String(), `<p class="text-muted">Notes</p>`))
+
+		for _, body := range []string{
+			"action=settings&icon=journal",
+			"action=settings&sort=relevance",
+			"action=settings&index=nope",
+			"action=settings&index=..%2Fnope",
+		} {
+			is.Equal(do(http.MethodPost, "/dirs/bar", body).Code, 400)
+		}
+	})
+
+	t.Run("move a post", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodPost, "/posts/"+post.ID+"/move", "dir=foo")
+		is.Equal(w.Code, 303)
+
+		p, err := app.posts.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Tags, []Tag{"_dir:/foo"})
+
+		is.Equal(do(http.MethodPost, "/posts/nope/move", "dir=foo").Code, 404)
+	})
+}
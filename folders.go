@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Name of the file keeping the settings of all folders in the posts
+// directory. Like other app data, it is not a post, and it is not committed
+// with the git backend, so folder settings are not synced.
+const FoldersFileName = ".folders.json"
+
+// FolderSettings are the settings of a folder. Folders themselves only exist
+// as folder tags of posts, so settings are kept separately by folder path.
+type FolderSettings struct {
+	// Post shown at the top of the folder page
+	IndexPostID string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	// Name of a Bootstrap icon, like `bi-journal`
+	Icon string `json:",omitempty"`
+	// Default order of the posts in the folder. Titles are sorted A to Z,
+	// and times newest first.
+	SortBy PostSortKey `json:",omitempty"`
+	// Manual order of the posts in the folder, by ID. Posts that are not
+	// listed come last, in the default order.
+	Order []string `json:",omitempty"`
+	// Manual order of the sub-folders, by name. Folders that are not listed
+	// come last, A to Z.
+	FolderOrder []string `json:",omitempty"`
+}
+
+// IsZero reports whether the settings are all defaults.
+func (s *FolderSettings) IsZero() bool {
+	return s == nil || (s.IndexPostID == "" && s.Description == "" && s.Icon == "" &&
+		s.SortBy == "" && len(s.Order) == 0 && len(s.FolderOrder) == 0)
+}
+
+var folderIconPattern = regexp.MustCompile(`^bi-[a-z0-9-]+$`)
+
+func (s *FolderSettings) validate() error {
+	if s.IndexPostID != "" && !plainIDPattern.MatchString(s.IndexPostID) {
+		return fmt.Errorf("invalid index post ID %q", s.IndexPostID)
+	}
+	for _, id := range s.Order {
+		if !plainIDPattern.MatchString(id) {
+			return fmt.Errorf("invalid post ID %q in order", id)
+		}
+	}
+	for _, name := range s.FolderOrder {
+		if name == "" || name != cleanDir(name) || strings.Contains(name, TagPathSeparator) {
+			return fmt.Errorf("invalid folder name %q in order", name)
+		}
+	}
+	if s.Icon != "" && !folderIconPattern.MatchString(s.Icon) {
+		return fmt.Errorf("invalid icon %q, expected a Bootstrap icon name like bi-journal", s.Icon)
+	}
+	switch s.SortBy {
+	case "", SortByCreated, SortByModified, SortByTitle:
+	default:
+		return fmt.Errorf("invalid sort key %q", s.SortBy)
+	}
+	return nil
+}
+
+// merge returns the settings of the folder once another folder, with the
+// settings other, is merged into it. Its own settings win, and the manual
+// order of the other folder's posts and sub-folders follows its own.
+func (s *FolderSettings) merge(other *FolderSettings) *FolderSettings {
+	merged := *s
+	if merged.IndexPostID == "" {
+		merged.IndexPostID = other.IndexPostID
+	}
+	if merged.Description == "" {
+		merged.Description = other.Description
+	}
+	if merged.Icon == "" {
+		merged.Icon = other.Icon
+	}
+	if merged.SortBy == "" {
+		merged.SortBy = other.SortBy
+	}
+	merged.Order = appendMissing(append([]string(nil), s.Order...), other.Order)
+	merged.FolderOrder = appendMissing(append([]string(nil), s.FolderOrder...), other.FolderOrder)
+	return &merged
+}
+
+// appendMissing appends the elements of more that are not in list yet.
+func appendMissing(list, more []string) []string {
+	for _, e := range more {
+		if !slices.Contains(list, e) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// sortPosts orders the posts of the folder.
+func (s *FolderSettings) sortPosts(posts []*Post) {
+	if s.SortBy != "" {
+		opts := &ListPostOptions{SortBy: s.SortBy, SortDescending: s.SortBy != SortByTitle}
+		opts.sortAndPage(posts)
+	}
+	orderPosts(posts, s.Order)
+	pinnedFirst(posts)
+}
+
+func (svc postsService) foldersFile() string {
+	return path.Join(svc.root, FoldersFileName)
+}
+
+// readFolderSettings returns the settings of all folders by path.
+func (svc postsService) readFolderSettings() (map[string]*FolderSettings, error) {
+	folders := make(map[string]*FolderSettings)
+
+	b, err := os.ReadFile(svc.foldersFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return folders, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &folders); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (svc postsService) writeFolderSettings(folders map[string]*FolderSettings) error {
+	b, err := json.MarshalIndent(folders, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(svc.foldersFile(), b, DefaultFileMode)
+}
+
+// GetFolderSettings returns the settings of a folder. Folders without any
+// settings get the defaults.
+func (svc postsService) GetFolderSettings(dir string) (*FolderSettings, error) {
+	folders, err := svc.readFolderSettings()
+	if err != nil {
+		return nil, fmt.Errorf("GetFolderSettings: %w", err)
+	}
+	if s, ok := folders[cleanDir(dir)]; ok {
+		return s, nil
+	}
+	return new(FolderSettings), nil
+}
+
+// UpdateFolderSettings replaces the settings of a folder. Default settings
+// are not stored.
+func (svc postsService) UpdateFolderSettings(dir string, s *FolderSettings) error {
+	err := svc.changeFolderSettings(dir, func(*FolderSettings) (*FolderSettings, error) {
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateFolderSettings: %w", err)
+	}
+	return nil
+}
+
+// ChangeFolderSettings replaces the settings of a folder with those returned
+// by change for the current settings. Other changes of folder settings wait
+// for it, so change must not use the service.
+func (svc postsService) ChangeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	if err := svc.changeFolderSettings(dir, change); err != nil {
+		return fmt.Errorf("ChangeFolderSettings: %w", err)
+	}
+	return nil
+}
+
+func (svc postsService) changeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	svc.foldersMu.Lock()
+	defer svc.foldersMu.Unlock()
+
+	folders, err := svc.readFolderSettings()
+	if err != nil {
+		return err
+	}
+
+	dir = cleanDir(dir)
+	current, ok := folders[dir]
+	if !ok {
+		current = new(FolderSettings)
+	}
+	s, err := change(current)
+	if err != nil {
+		return err
+	}
+
+	if s.IsZero() {
+		if !ok {
+			return nil
+		}
+		delete(folders, dir)
+	} else {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		folders[dir] = s
+	}
+
+	return svc.writeFolderSettings(folders)
+}
+
+// SetFolderOrder changes the manual order of the posts in a folder.
+func SetFolderOrder(svc PostsService, dir string, postIDs []string) error {
+	err := svc.ChangeFolderSettings(dir, func(s *FolderSettings) (*FolderSettings, error) {
+		s.Order = postIDs
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("SetFolderOrder: %w", err)
+	}
+	return nil
+}
+
+// SetSubfolderOrder changes the manual order of the sub-folders of a folder.
+func SetSubfolderOrder(svc PostsService, dir string, names []string) error {
+	err := svc.ChangeFolderSettings(dir, func(s *FolderSettings) (*FolderSettings, error) {
+		s.FolderOrder = names
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("SetSubfolderOrder: %w", err)
+	}
+	return nil
+}
+
+// orderPosts sorts posts in the order of their IDs in order. Posts that are
+// not listed keep their relative order after the listed ones.
+func orderPosts(posts []*Post, order []string) {
+	if len(order) == 0 {
+		return
+	}
+
+	pos := make(map[string]int, len(order))
+	for i, id := range order {
+		pos[id] = i
+	}
+	rank := func(p *Post) int {
+		if i, ok := pos[p.ID]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		return rank(posts[i]) < rank(posts[j])
+	})
+}
+
+// orderFolders sorts folders in the order of their names in order. Folders
+// that are not listed keep their relative order after the listed ones.
+func orderFolders(folders []*Node, order []string) {
+	if len(order) == 0 {
+		return
+	}
+
+	rank := func(n *Node) int {
+		if i := slices.Index(order, n.Label); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.SliceStable(folders, func(i, j int) bool {
+		return rank(folders[i]) < rank(folders[j])
+	})
+}
+
+// applyFolderSettings adds the settings of all folders to a folder tree, and
+// orders the posts and sub-folders of each folder by them.
+func applyFolderSettings(tree *Node, folders map[string]*FolderSettings) {
+	if s, ok := folders[tree.Path]; ok {
+		tree.Settings = s
+		s.sortPosts(tree.Value)
+		orderFolders(tree.Children, s.FolderOrder)
+	}
+	for _, child := range tree.Children {
+		applyFolderSettings(child, folders)
+	}
+}
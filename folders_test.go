@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFolderSettings(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	var posts []*Post
+	for _, title := range []string{"a", "b", "c"} {
+		p := &Post{Title: title, Tags: []Tag{"_dir:/foo/bar"}}
+		is.NoErr(svc.CreatePost(p))
+		posts = append(posts, p)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		is := is.New(t)
+		s, err := svc.GetFolderSettings("foo/bar")
+		is.NoErr(err)
+		is.True(s.IsZero())
+	})
+
+	t.Run("manual order", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(SetFolderOrder(svc, "/foo/bar/", []string{posts[2].ID, "gone", posts[0].ID}))
+
+		s, err := svc.GetFolderSettings("foo/bar")
+		is.NoErr(err)
+		is.Equal(len(s.Order), 3)
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		bar := tree.Find("foo/bar")
+		is.Equal(bar.Value[0].ID, posts[2].ID)
+		is.Equal(bar.Value[1].ID, posts[0].ID)
+		is.Equal(bar.Value[2].ID, posts[1].ID)
+	})
+
+	t.Run("default order", func(t *testing.T) {
+		is := is.New(t)
+		s, err := svc.GetFolderSettings("foo/bar")
+		is.NoErr(err)
+		s.SortBy = SortByTitle
+		s.Order = []string{posts[1].ID}
+		is.NoErr(svc.UpdateFolderSettings("foo/bar", s))
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		bar := tree.Find("foo/bar")
+		is.Equal(bar.Settings.SortBy, SortByTitle)
+		is.Equal(bar.Value[0].Title, "b")
+		is.Equal(bar.Value[1].Title, "a")
+		is.Equal(bar.Value[2].Title, "c")
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		is := is.New(t)
+		is.True(svc.UpdateFolderSettings("foo", &FolderSettings{Icon: "<script>"}) != nil)
+		is.True(svc.UpdateFolderSettings("foo", &FolderSettings{SortBy: SortByRelevance}) != nil)
+		is.True(svc.UpdateFolderSettings("foo", &FolderSettings{IndexPostID: "../x"}) != nil)
+		is.True(svc.UpdateFolderSettings("foo", &FolderSettings{Order: []string{"../x"}}) != nil)
+	})
+
+	t.Run("settings move with the folder", func(t *testing.T) {
+		is := is.New(t)
+		_, err := MoveDir(svc, "foo", "baz")
+		is.NoErr(err)
+
+		s, err := svc.GetFolderSettings("baz/bar")
+		is.NoErr(err)
+		is.Equal(s.Order[0], posts[1].ID)
+
+		s, err = svc.GetFolderSettings("foo/bar")
+		is.NoErr(err)
+		is.True(s.IsZero())
+	})
+
+	t.Run("settings are merged with those of an existing folder", func(t *testing.T) {
+		is := is.New(t)
+		other := &Post{Title: "d", Tags: []Tag{"_dir:/qux"}}
+		is.NoErr(svc.CreatePost(other))
+		is.NoErr(svc.UpdateFolderSettings("qux", &FolderSettings{Icon: "bi-box", Description: "qux", Order: []string{other.ID}}))
+		is.NoErr(svc.UpdateFolderSettings("baz/bar", &FolderSettings{Description: "bar", Order: []string{posts[1].ID}}))
+
+		_, err := MoveDir(svc, "qux", "baz/bar")
+		is.NoErr(err)
+		s, err := svc.GetFolderSettings("baz/bar")
+		is.NoErr(err)
+		is.Equal(s.Description, "bar")
+		is.Equal(s.Icon, "bi-box")
+		is.Equal(s.Order, []string{posts[1].ID, other.ID})
+
+		is.NoErr(svc.DeletePost(other.ID))
+	})
+
+	t.Run("renamed folders keep their position", func(t *testing.T) {
+		is := is.New(t)
+		other := &Post{Title: "d", Tags: []Tag{"_dir:/baz/a"}}
+		is.NoErr(svc.CreatePost(other))
+		is.NoErr(SetSubfolderOrder(svc, "baz", []string{"bar", "a"}))
+
+		_, err := MoveDir(svc, "baz/bar", "baz/zzz")
+		is.NoErr(err)
+		s, err := svc.GetFolderSettings("baz")
+		is.NoErr(err)
+		is.Equal(s.FolderOrder, []string{"zzz", "a"})
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Find("baz").Children[0].Label, "zzz")
+
+		_, err = MoveDir(svc, "baz/zzz", "baz/bar")
+		is.NoErr(err)
+		is.NoErr(svc.UpdateFolderSettings("baz", nil))
+		is.NoErr(svc.DeletePost(other.ID))
+	})
+
+	t.Run("default settings are removed", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.UpdateFolderSettings("baz/bar", &FolderSettings{}))
+
+		folders, err := svc.(*postsService).readFolderSettings()
+		is.NoErr(err)
+		is.Equal(len(folders), 0)
+	})
+}
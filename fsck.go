@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Name of the directory, relative to the posts root, where Fsck moves files
+// that are not healthy posts. Every run has its own sub-directory.
+const QuarantineDirName = ".quarantine"
+
+// Temporary files younger than this might still be written to
+const tempFileGracePeriod = time.Minute
+
+type FsckProblem string
+
+const (
+	// The file can not be read or parsed
+	FsckCorrupt FsckProblem = "corrupt"
+	// The ID in the file differs from the one in the file name
+	FsckIDMismatch FsckProblem = "id-mismatch"
+	// The post is also stored in Markdown, and this file is not used
+	FsckDuplicate FsckProblem = "duplicate"
+	// The file is not a post, like a temporary file left over by a crash
+	FsckStray FsckProblem = "stray"
+)
+
+// An FsckIssue is a file in the posts root that is not a healthy post, or a
+// temporary file left over in the posts root or the revisions.
+type FsckIssue struct {
+	// File name, relative to the posts root
+	Name    string
+	Problem FsckProblem
+	Detail  string
+	// Path of the file relative to the posts root, once quarantined
+	Quarantined string
+}
+
+// An FsckReport is the result of checking all files in the posts root.
+type FsckReport struct {
+	// Number of healthy posts
+	Posts  int
+	Issues []*FsckIssue
+}
+
+// Unresolved returns the number of issues with files that were not
+// quarantined.
+func (r *FsckReport) Unresolved() int {
+	var n int
+	for _, issue := range r.Issues {
+		if issue.Quarantined == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Fscker is implemented by posts services that can check their storage.
+type Fscker interface {
+	Fsck(quarantine bool) (*FsckReport, error)
+}
+
+// Fsck checks all files in the posts root. If quarantine is true, files with
+// problems are moved to a new sub-directory of the quarantine directory, so
+// they can be looked at while the healthy posts are served.
+func (svc postsService) Fsck(quarantine bool) (*FsckReport, error) {
+	entries, err := os.ReadDir(svc.root)
+	if err != nil {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+
+	report := new(FsckReport)
+	issue := func(name string, problem FsckProblem, format string, args ...any) {
+		report.Issues = append(report.Issues, &FsckIssue{
+			Name:    name,
+			Problem: problem,
+			Detail:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case isTempFile(name):
+			// Posts, and the folder settings
+			stale, err := isStaleTempFile(e)
+			if err != nil {
+				return nil, fmt.Errorf("Fsck: %w", err)
+			}
+			if stale {
+				issue(name, FsckStray, "left over by an interrupted write")
+			}
+			continue
+		case strings.HasPrefix(name, "."):
+			// App data, like the trash
+			continue
+		case e.IsDir():
+			issue(name, FsckStray, "posts are not stored in sub-directories")
+			continue
+		}
+
+		if problem, detail := svc.checkPostFile(name); problem != "" {
+			issue(name, problem, "%s", detail)
+			continue
+		}
+		report.Posts++
+	}
+
+	// Revisions are written the same way as posts
+	revDirs, err := os.ReadDir(path.Join(svc.root, RevisionsDirName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("Fsck: %w", err)
+	}
+	for _, d := range revDirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := path.Join(RevisionsDirName, d.Name())
+		entries, err := os.ReadDir(path.Join(svc.root, dir))
+		if err != nil {
+			return nil, fmt.Errorf("Fsck: %w", err)
+		}
+		for _, e := range entries {
+			if !isTempFile(e.Name()) {
+				continue
+			}
+			stale, err := isStaleTempFile(e)
+			if err != nil {
+				return nil, fmt.Errorf("Fsck: %w", err)
+			}
+			if stale {
+				issue(path.Join(dir, e.Name()), FsckStray, "left over by an interrupted write")
+			}
+		}
+	}
+
+	if quarantine && len(report.Issues) > 0 {
+		if err := svc.quarantine(report.Issues); err != nil {
+			return report, fmt.Errorf("Fsck: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+// checkPostFile checks a file in the posts root. Returns no problem if it is
+// a healthy post.
+func (svc postsService) checkPostFile(name string) (FsckProblem, string) {
+	id := postIDFromFilename(name)
+	p, err := readPostFile(path.Join(svc.root, name))
+	if err != nil {
+		return FsckCorrupt, err.Error()
+	}
+	if p.ID != id {
+		return FsckIDMismatch, fmt.Sprintf("file has ID %q", p.ID)
+	}
+	if name == id {
+		if _, err := os.Stat(path.Join(svc.root, id+MarkdownExt)); err == nil {
+			return FsckDuplicate, fmt.Sprintf("%s is used instead", id+MarkdownExt)
+		}
+	}
+	return "", ""
+}
+
+// isStaleTempFile reports whether a temporary file is old enough to no longer
+// be written to.
+func isStaleTempFile(e fs.DirEntry) (bool, error) {
+	fi, err := e.Info()
+	if err != nil {
+		return false, err
+	}
+	return time.Since(fi.ModTime()) >= tempFileGracePeriod, nil
+}
+
+func (svc postsService) quarantine(issues []*FsckIssue) error {
+	dir := path.Join(QuarantineDirName, time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(path.Join(svc.root, dir), 0750); err != nil {
+		return err
+	}
+	// The index might hold any of the posts being moved
+	defer svc.index.invalidate()
+
+	for _, issue := range issues {
+		moved := path.Join(dir, issue.Name)
+		if err := os.MkdirAll(path.Dir(path.Join(svc.root, moved)), 0750); err != nil {
+			return err
+		}
+		// The post might have been written since it was checked, so check
+		// it again while it can't be
+		unlock := svc.locks.lock(postIDFromFilename(issue.Name))
+		var fixed bool
+		if issue.Problem != FsckStray {
+			problem, _ := svc.checkPostFile(issue.Name)
+			fixed = problem == ""
+		}
+		var err error
+		if !fixed {
+			err = os.Rename(path.Join(svc.root, issue.Name), path.Join(svc.root, moved))
+		}
+		unlock()
+		if fixed || errors.Is(err, fs.ErrNotExist) {
+			// Fixed in the meantime, like by an update or a finished write
+			continue
+		} else if err != nil {
+			return err
+		}
+		issue.Quarantined = moved
+	}
+	return nil
+}
+
+// FsckHandler checks the posts root, and quarantines broken files on POST.
+func (app *App) FsckHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fscker, ok := app.posts.(Fscker)
+		if !ok {
+			http.Error(w, "the storage backend can not be checked", http.StatusNotImplemented)
+			return
+		}
+
+		report, err := fscker.Fsck(r.Method == http.MethodPost)
+		if err != nil {
+			log.Printf("error: FsckHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 500)
+			return
+		}
+
+		locals := app.buildLocals(struct {
+			Report *FsckReport
+		}{
+			Report: report,
+		})
+
+		if err := app.templates.ExecuteTemplate(w, "fsck.html", locals); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
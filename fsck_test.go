@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFsck(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	svc := NewPostsService(dir)
+	a := &Post{Title: "a"}
+	is.NoErr(svc.CreatePost(a))
+	b := &Post{Title: "b"}
+	is.NoErr(svc.CreatePost(b))
+
+	write := func(name, content string) {
+		is.NoErr(os.WriteFile(path.Join(dir, name), []byte(content), DefaultFileMode))
+	}
+	// A post truncated by a crash
+	write("2Q0oIVU8L3b6QGfxeTvF0ZfvjWW", `{"ID": "2Q0oIVU8L3b6QGfxeTvF0Zf`)
+	// A copy of a post under another name
+	orig, err := os.ReadFile(path.Join(dir, a.ID))
+	is.NoErr(err)
+	write("copy", string(orig))
+	// A post stored in both formats
+	write(b.ID+MarkdownExt, "---\nid: "+b.ID+"\ntitle: b\n---\n")
+	// A temporary file left over by a crash, and one still being written
+	write("."+a.ID+tempFileInfix+"1", "{")
+	old := time.Now().Add(-time.Hour)
+	is.NoErr(os.Chtimes(path.Join(dir, "."+a.ID+tempFileInfix+"1"), old, old))
+	write("."+a.ID+tempFileInfix+"2", "{")
+	// Left over temporary files of the folder settings and a revision
+	foldersTemp := "." + FoldersFileName + tempFileInfix + "1"
+	write(foldersTemp, "{")
+	is.NoErr(os.Chtimes(path.Join(dir, foldersTemp), old, old))
+	a.Title = "a2"
+	is.NoErr(svc.UpdatePost(a))
+	revTemp := path.Join(RevisionsDirName, a.ID, ".rev"+tempFileInfix+"1")
+	write(revTemp, "{")
+	is.NoErr(os.Chtimes(path.Join(dir, revTemp), old, old))
+	is.NoErr(os.Mkdir(path.Join(dir, "stuff"), 0750))
+
+	t.Run("broken files do not break listing", func(t *testing.T) {
+		is := is.New(t)
+		svc := NewPostsService(dir)
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+
+		revs, err := svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 1)
+	})
+
+	t.Run("report problems", func(t *testing.T) {
+		is := is.New(t)
+		report, err := svc.(Fscker).Fsck(false)
+		is.NoErr(err)
+		is.Equal(report.Posts, 2)
+
+		problems := make(map[string]FsckProblem)
+		for _, issue := range report.Issues {
+			problems[issue.Name] = issue.Problem
+			is.Equal(issue.Quarantined, "")
+		}
+		is.Equal(problems, map[string]FsckProblem{
+			"2Q0oIVU8L3b6QGfxeTvF0ZfvjWW":    FsckCorrupt,
+			"copy":                           FsckIDMismatch,
+			b.ID:                             FsckDuplicate,
+			"." + a.ID + tempFileInfix + "1": FsckStray,
+			foldersTemp:                      FsckStray,
+			revTemp:                          FsckStray,
+			"stuff":                          FsckStray,
+		})
+		is.Equal(report.Unresolved(), 7)
+	})
+
+	t.Run("fsck page", func(t *testing.T) {
+		is := is.New(t)
+		app := NewApp(svc, ":1337")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fsck", nil))
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), "copy"))
+	})
+
+	t.Run("posts fixed since the check are not quarantined", func(t *testing.T) {
+		is := is.New(t)
+		c := &Post{Title: "c"}
+		is.NoErr(svc.CreatePost(c))
+
+		issues := []*FsckIssue{{Name: c.ID, Problem: FsckCorrupt}}
+		is.NoErr(svc.(*postsService).quarantine(issues))
+		is.Equal(issues[0].Quarantined, "")
+
+		_, err := svc.GetPost(c.ID)
+		is.NoErr(err)
+		is.NoErr(svc.DeletePost(c.ID))
+	})
+
+	t.Run("quarantine problems", func(t *testing.T) {
+		is := is.New(t)
+		report, err := svc.(Fscker).Fsck(true)
+		is.NoErr(err)
+		is.Equal(len(report.Issues), 7)
+		is.Equal(report.Unresolved(), 0)
+
+		for _, issue := range report.Issues {
+			_, err := os.Stat(path.Join(dir, issue.Quarantined))
+			is.NoErr(err)
+		}
+
+		report, err = svc.(Fscker).Fsck(false)
+		is.NoErr(err)
+		is.Equal(len(report.Issues), 0)
+
+		p, err := svc.GetPost(b.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "b")
+	})
+}
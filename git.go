@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the git remote used to sync posts between machines.
+const GitRemoteName = "origin"
+
+// ErrSyncConflict is returned when changes pulled from the remote conflict
+// with local ones, like when both changed the same post.
+var ErrSyncConflict = errors.New("changes on the remote conflict with local changes")
+
+// gitPostsService stores posts like postsService, but commits every change
+// to a git repository in the root directory. The git log of a post is used
+// as its revision history.
+type gitPostsService struct {
+	postsService
+
+	// URL or path of the remote repository to sync with. Syncing is
+	// disabled if empty.
+	remote string
+
+	// Serializes git commands, which would otherwise fight over the index
+	mu sync.Mutex
+}
+
+// NewGitPostsService returns a PostsService backed by a git repository in
+// root. The repository is created if it does not exist, and any existing
+// posts are committed. If remote is not empty, it is configured as the
+// remote to sync with.
+func NewGitPostsService(root string, format PostFormat, remote string) (*gitPostsService, error) {
+	svc := &gitPostsService{
+		postsService: postsService{root: root, format: format, index: newPostIndex(), foldersMu: new(sync.Mutex), locks: newPostLocks()},
+		remote:       remote,
+	}
+
+	if err := svc.init(); err != nil {
+		return nil, fmt.Errorf("NewGitPostsService: %w", err)
+	}
+
+	return svc, nil
+}
+
+func (svc *gitPostsService) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = svc.root
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(out), nil
+}
+
+func (svc *gitPostsService) init() error {
+	if err := os.MkdirAll(svc.root, 0750); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path.Join(svc.root, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if _, err := svc.git("init", "--quiet", "--initial-branch=main"); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Commits need an identity, but the user might not have configured one
+	for k, v := range map[string]string{
+		"user.name":  "knowledge-base",
+		"user.email": "knowledge-base@localhost",
+	} {
+		if _, err := svc.git("config", k); err != nil {
+			if _, err := svc.git("config", k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	// App data that is not a post, like the attachments, is kept out of the
+	// repository. This is not done with a .gitignore, as it would conflict
+	// with the first pull from a remote.
+	if err := svc.exclude(".*"); err != nil {
+		return err
+	}
+
+	if svc.remote != "" {
+		if _, err := svc.git("remote", "get-url", GitRemoteName); err != nil {
+			_, err = svc.git("remote", "add", GitRemoteName, svc.remote)
+			if err != nil {
+				return err
+			}
+		} else if _, err := svc.git("remote", "set-url", GitRemoteName, svc.remote); err != nil {
+			return err
+		}
+	}
+
+	// Pull before committing anything, so a fresh data dir can be
+	// populated from the remote.
+	if err := svc.pull(); err != nil {
+		return err
+	}
+
+	status, err := svc.git("status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		if _, err := svc.git("add", "--all"); err != nil {
+			return err
+		}
+		if _, err := svc.git("commit", "--quiet", "--message", "Import existing posts"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exclude adds a pattern to the excludes of the repository, keeping any
+// others the user might have added.
+func (svc *gitPostsService) exclude(pattern string) error {
+	exclude := path.Join(svc.root, ".git", "info", "exclude")
+	b, err := os.ReadFile(exclude)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if slices.Contains(strings.Split(string(b), "\n"), pattern) {
+		return nil
+	}
+
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	b = append(b, pattern+"\n"...)
+	if err := os.MkdirAll(path.Dir(exclude), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(exclude, b, DefaultFileMode)
+}
+
+// postPathspec matches the files of a post in all formats.
+func postPathspec(id string) string {
+	return id + "*"
+}
+
+// commit records the current state of a single post.
+func (svc *gitPostsService) commit(id, message string) error {
+	if _, err := svc.git("add", "--all", "--", postPathspec(id)); err != nil {
+		return err
+	}
+	if _, err := svc.git("commit", "--quiet", "--message", message, "--", postPathspec(id)); err != nil {
+		return err
+	}
+
+	if svc.remote != "" {
+		// The change is safely committed locally, so a failed push is not
+		// an error for the caller. It will be retried on the next sync.
+		if err := svc.push(); err != nil {
+			// The remote most likely has changes we don't have yet
+			if err := svc.pull(); err != nil {
+				log.Printf("error: failed to pull posts: %v", err)
+			} else if err := svc.push(); err != nil {
+				log.Printf("error: failed to push posts: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (svc *gitPostsService) branch() (string, error) {
+	out, err := svc.git("symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (svc *gitPostsService) pull() error {
+	if svc.remote == "" {
+		return nil
+	}
+
+	branch, err := svc.branch()
+	if err != nil {
+		return err
+	}
+
+	// Nothing to pull from a new, empty remote
+	out, err := svc.git("ls-remote", "--heads", GitRemoteName, branch)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == "" {
+		return nil
+	}
+
+	if _, err := svc.git("pull", "--quiet", "--rebase", "--autostash", GitRemoteName, branch); err != nil {
+		// Leave the repository as it was, instead of in the middle of a
+		// rebase no further commit could be made in. The local commits are
+		// kept, and pushed once the conflict is resolved by hand.
+		if svc.isRebasing() {
+			if _, abortErr := svc.git("rebase", "--abort"); abortErr != nil {
+				return fmt.Errorf("%w, and failed to abort: %v", err, abortErr)
+			}
+			return fmt.Errorf("%w: %v", ErrSyncConflict, err)
+		}
+		return err
+	}
+
+	// Any post might have changed
+	svc.index.invalidate()
+	return nil
+}
+
+// isRebasing reports whether the repository is in the middle of a rebase.
+func (svc *gitPostsService) isRebasing() bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(path.Join(svc.root, ".git", dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *gitPostsService) push() error {
+	if svc.remote == "" {
+		return nil
+	}
+
+	// Nothing to push before the first commit
+	if _, err := svc.git("rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return nil
+	}
+
+	branch, err := svc.branch()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.git("push", "--quiet", GitRemoteName, "HEAD:"+branch)
+	return err
+}
+
+// Sync pulls changes from the remote, rebasing any local commits on top, and
+// pushes the result back.
+func (svc *gitPostsService) Sync() error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if err := svc.pull(); err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+	if err := svc.push(); err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+
+	return nil
+}
+
+// Runs Sync at the given interval until the program exits.
+func (svc *gitPostsService) syncPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := svc.Sync(); err != nil {
+			log.Printf("error: failed to sync posts: %v", err)
+		}
+	}
+}
+
+func (svc *gitPostsService) CreatePost(p *Post) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if err := svc.postsService.CreatePost(p); err != nil {
+		return err
+	}
+
+	if err := svc.commit(p.ID, fmt.Sprintf("Create post %q", p.Title)); err != nil {
+		return fmt.Errorf("CreatePost: %w", err)
+	}
+
+	return nil
+}
+
+func (svc *gitPostsService) UpdatePost(p *Post) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	prev, err := svc.GetPost(p.ID)
+	if err != nil {
+		return fmt.Errorf("UpdatePost: %w", err)
+	}
+
+	if err := svc.postsService.UpdatePost(p); err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("Update post %q", p.Title)
+	if prev.Title != p.Title {
+		msg = fmt.Sprintf("Rename post %q to %q", prev.Title, p.Title)
+	}
+	if err := svc.commit(p.ID, msg); err != nil {
+		return fmt.Errorf("UpdatePost: %w", err)
+	}
+
+	return nil
+}
+
+func (svc *gitPostsService) DeletePost(id string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	p, err := svc.GetPost(id)
+	if err != nil {
+		return fmt.Errorf("DeletePost: %w", err)
+	}
+
+	if err := svc.postsService.DeletePost(id); err != nil {
+		return err
+	}
+
+	if err := svc.commit(id, fmt.Sprintf("Delete post %q", p.Title)); err != nil {
+		return fmt.Errorf("DeletePost: %w", err)
+	}
+
+	return nil
+}
+
+func (svc *gitPostsService) RestorePost(id string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if err := svc.postsService.RestorePost(id); err != nil {
+		return err
+	}
+
+	p, err := svc.GetPost(id)
+	if err != nil {
+		return fmt.Errorf("RestorePost: %w", err)
+	}
+
+	if err := svc.commit(id, fmt.Sprintf("Restore post %q", p.Title)); err != nil {
+		return fmt.Errorf("RestorePost: %w", err)
+	}
+
+	return nil
+}
+
+// Returns all previous versions of a post from the git log, newest first.
+// Revision IDs are commit hashes.
+func (svc *gitPostsService) ListRevisions(postID string) ([]*Revision, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	// Commits deleting the post have no version to show
+	out, err := svc.git("log", "--format=%H", "--diff-filter=AM", "--", postPathspec(postID))
+	if err != nil {
+		return nil, fmt.Errorf("ListRevisions: %w", err)
+	}
+
+	hashes := strings.Fields(out)
+	if len(hashes) == 0 || svc.isPurged(postID) {
+		return nil, nil
+	}
+
+	var revs []*Revision
+	for _, hash := range hashes {
+		rev, err := svc.getRevision(postID, hash)
+		if err != nil {
+			return nil, fmt.Errorf("ListRevisions: %w", err)
+		}
+		// Restoring a post from the trash commits the same version again
+		if n := len(revs); n > 0 && revs[n-1].Version() == rev.Version() {
+			continue
+		}
+		revs = append(revs, rev)
+	}
+
+	// The latest commit is the current version
+	return revs[1:], nil
+}
+
+// isPurged reports whether a post was purged, along with its revisions, even
+// though git still has them.
+func (svc *gitPostsService) isPurged(postID string) bool {
+	for _, dir := range []string{svc.root, svc.trashDir()} {
+		if _, err := findPostFile(dir, postID); !errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a version of a post as it was in the given commit.
+func (svc *gitPostsService) GetRevision(postID, revisionID string) (*Revision, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	rev, err := svc.getRevision(postID, revisionID)
+	if err != nil {
+		return nil, fmt.Errorf("GetRevision: %w", err)
+	}
+
+	return rev, nil
+}
+
+func (svc *gitPostsService) getRevision(postID, hash string) (*Revision, error) {
+	// Only commit hashes, which are never options or paths
+	if !plainIDPattern.MatchString(hash) || svc.isPurged(postID) {
+		return nil, fmt.Errorf("revision %s: %w", hash, fs.ErrNotExist)
+	}
+
+	var (
+		name string
+		out  string
+		err  error
+	)
+	for _, name = range []string{postID + MarkdownExt, postID} {
+		if out, err = svc.git("show", hash+":"+name); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("revision %s: %w: %v", hash, fs.ErrNotExist, err)
+	}
+
+	post, err := decodePost(name, []byte(out))
+	if err != nil {
+		return nil, err
+	}
+
+	rev := post.Revision()
+	rev.ID = hash
+	return rev, nil
+}
+
+func (svc *gitPostsService) ConvertToMarkdown() (int, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	n, err := svc.postsService.ConvertToMarkdown()
+	if err != nil || n == 0 {
+		return n, err
+	}
+
+	if _, err := svc.git("add", "--all"); err != nil {
+		return n, fmt.Errorf("ConvertToMarkdown: %w", err)
+	}
+	if _, err := svc.git("commit", "--quiet", "--message", "Convert posts to Markdown"); err != nil {
+		return n, fmt.Errorf("ConvertToMarkdown: %w", err)
+	}
+	if err := svc.push(); err != nil {
+		log.Printf("error: failed to push posts: %v", err)
+	}
+
+	return n, nil
+}
+
+func (svc *gitPostsService) Fsck(quarantine bool) (*FsckReport, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	report, err := svc.postsService.Fsck(quarantine)
+	if err != nil || report.Unresolved() == len(report.Issues) {
+		return report, err
+	}
+
+	// Stray files are not in the repository
+	status, err := svc.git("status", "--porcelain")
+	if err != nil || status == "" {
+		return report, err
+	}
+	if _, err := svc.git("add", "--all"); err != nil {
+		return report, fmt.Errorf("Fsck: %w", err)
+	}
+	if _, err := svc.git("commit", "--quiet", "--message", "Quarantine broken posts"); err != nil {
+		return report, fmt.Errorf("Fsck: %w", err)
+	}
+	if err := svc.push(); err != nil {
+		log.Printf("error: failed to push posts: %v", err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestGitPosts(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc, err := NewGitPostsService(tmpdir, FormatJSON, "")
+	is.NoErr(err)
+
+	gitLog := func() []string {
+		out, err := svc.git("log", "--format=%s")
+		is.NoErr(err)
+		return strings.Split(strings.TrimSpace(out), "\n")
+	}
+
+	post := &Post{Title: "foo", Content: "bar"}
+
+	t.Run("creating a post commits it", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.CreatePost(post))
+		is.Equal(gitLog(), []string{`Create post "foo"`})
+	})
+
+	t.Run("updating a post commits it", func(t *testing.T) {
+		is := is.New(t)
+		post.Content = "baz"
+		is.NoErr(svc.UpdatePost(post))
+		post.Title = "foo2"
+		is.NoErr(svc.UpdatePost(post))
+		is.Equal(gitLog()[:2], []string{`Rename post "foo" to "foo2"`, `Update post "foo"`})
+	})
+
+	t.Run("the git log is the post history", func(t *testing.T) {
+		is := is.New(t)
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 2)
+		is.Equal(revs[0].Title, "foo")
+		is.Equal(revs[0].Content, "baz")
+		is.Equal(revs[1].Content, "bar")
+
+		rev, err := svc.GetRevision(post.ID, revs[1].ID)
+		is.NoErr(err)
+		is.Equal(rev, revs[1])
+
+		// No revision files are written next to the posts
+		_, err = os.Stat(path.Join(tmpdir, RevisionsDirName))
+		is.True(os.IsNotExist(err))
+	})
+
+	t.Run("deleting and restoring a post commits it", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(post.ID))
+		is.NoErr(svc.RestorePost(post.ID))
+		is.Equal(gitLog()[:2], []string{`Restore post "foo2"`, `Delete post "foo2"`})
+
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 2) // neither the deletion nor the restore has a version of its own
+		is.Equal(revs[0].Title, "foo")
+		is.Equal(revs[0].Content, "baz")
+	})
+
+	t.Run("existing posts are imported", func(t *testing.T) {
+		is := is.New(t)
+
+		dir, err := os.MkdirTemp("", "posts")
+		is.NoErr(err)
+		defer os.RemoveAll(dir)
+		is.NoErr(NewPostsService(dir).CreatePost(&Post{Title: "old"}))
+
+		svc, err := NewGitPostsService(dir, FormatJSON, "")
+		is.NoErr(err)
+		out, err := svc.git("log", "--format=%s")
+		is.NoErr(err)
+		is.Equal(strings.TrimSpace(out), "Import existing posts")
+	})
+
+	t.Run("excludes of the user are kept", func(t *testing.T) {
+		is := is.New(t)
+		exclude := path.Join(tmpdir, ".git", "info", "exclude")
+		is.NoErr(os.WriteFile(exclude, []byte("*.bak"), DefaultFileMode))
+
+		_, err := NewGitPostsService(tmpdir, FormatJSON, "")
+		is.NoErr(err)
+		_, err = NewGitPostsService(tmpdir, FormatJSON, "")
+		is.NoErr(err)
+		b, err := os.ReadFile(exclude)
+		is.NoErr(err)
+		is.Equal(string(b), "*.bak\n.*\n")
+	})
+}
+
+func TestGitPostsSync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	remote := path.Join(tmpdir, "remote.git")
+	out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput()
+	is.NoErr(err)
+	t.Log(string(out))
+
+	a, err := NewGitPostsService(path.Join(tmpdir, "a"), FormatJSON, remote)
+	is.NoErr(err)
+
+	post := &Post{Title: "from a"}
+	is.NoErr(a.CreatePost(post)) // pushed right away
+
+	b, err := NewGitPostsService(path.Join(tmpdir, "b"), FormatMarkdown, remote)
+	is.NoErr(err)
+
+	t.Run("a new machine gets posts from the remote", func(t *testing.T) {
+		is := is.New(t)
+		p, err := b.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "from a")
+	})
+
+	t.Run("changes are synced both ways", func(t *testing.T) {
+		is := is.New(t)
+
+		p, err := b.GetPost(post.ID)
+		is.NoErr(err)
+		p.Content = "edited on b"
+		is.NoErr(b.UpdatePost(p))
+		is.NoErr(a.CreatePost(&Post{Title: "another from a"}))
+
+		is.NoErr(a.Sync())
+		is.NoErr(b.Sync())
+
+		p, err = a.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "edited on b")
+
+		posts, err := b.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+	})
+
+	t.Run("conflicting changes leave the repository working", func(t *testing.T) {
+		is := is.New(t)
+
+		pa, err := a.GetPost(post.ID)
+		is.NoErr(err)
+		pa.Content = "edited on a again"
+		is.NoErr(a.UpdatePost(pa))
+
+		pb, err := b.GetPost(post.ID)
+		is.NoErr(err)
+		pb.Content = "edited on b again"
+		is.NoErr(b.UpdatePost(pb))
+
+		is.True(errors.Is(b.Sync(), ErrSyncConflict))
+		is.True(!b.isRebasing())
+
+		// Local changes are kept, and can still be made
+		pb, err = b.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(pb.Content, "edited on b again")
+		is.NoErr(b.CreatePost(&Post{Title: "after the conflict"}))
+		_, err = b.branch()
+		is.NoErr(err)
+	})
+}
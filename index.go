@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// postIndex is an in-memory cache of all posts in the posts root. It is
+// loaded from disk on first use.
+type postIndex struct {
+	mu     sync.RWMutex
+	loaded bool
+	posts  map[string]*Post
+	search *searchIndex
+	links  *linkIndex
+}
+
+func newPostIndex() *postIndex {
+	return &postIndex{}
+}
+
+// clonePost returns a copy of a post that can be modified without affecting
+// the original.
+func clonePost(p *Post) *Post {
+	c := *p
+	c.Tags = append([]Tag(nil), p.Tags...)
+	return &c
+}
+
+// load reads all posts from disk, unless already loaded.
+func (idx *postIndex) load(svc postsService) error {
+	idx.mu.RLock()
+	loaded := idx.loaded
+	idx.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded {
+		return nil
+	}
+
+	ids, err := svc.getAllPostIDs()
+	if err != nil {
+		return err
+	}
+
+	posts := make(map[string]*Post, len(ids))
+	search := newSearchIndex()
+	links := newLinkIndex()
+	for _, id := range ids {
+		// A broken post file must not take all other posts down with it.
+		// Fsck reports and quarantines it.
+		p, err := svc.readPost(id)
+		if err != nil {
+			log.Printf("error: skipping post %s: %v", id, err)
+			continue
+		}
+		if p.ID != id {
+			log.Printf("error: skipping post %s: file has ID %q", id, p.ID)
+			continue
+		}
+		posts[id] = p
+		search.add(p)
+		links.add(p)
+	}
+
+	idx.posts = posts
+	idx.search = search
+	idx.links = links
+	idx.loaded = true
+	return nil
+}
+
+// invalidate makes the index reload all posts on next use.
+func (idx *postIndex) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.loaded = false
+	idx.posts = nil
+	idx.search = nil
+	idx.links = nil
+}
+
+func (idx *postIndex) get(id string) (*Post, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	p, ok := idx.posts[id]
+	if !ok {
+		return nil, false
+	}
+	return clonePost(p), true
+}
+
+// all returns a copy of all posts, ordered by ID.
+func (idx *postIndex) all() []*Post {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	posts := make([]*Post, 0, len(idx.posts))
+	for _, p := range idx.posts {
+		posts = append(posts, clonePost(p))
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].ID < posts[j].ID
+	})
+	return posts
+}
+
+func (idx *postIndex) set(p *Post) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded {
+		idx.posts[p.ID] = clonePost(p)
+		idx.search.add(p)
+		idx.links.add(p)
+	}
+}
+
+func (idx *postIndex) remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.loaded {
+		delete(idx.posts, id)
+		idx.search.remove(id)
+		idx.links.remove(id)
+	}
+}
+
+// find returns a copy of all posts matching a full-text search query, best
+// match first. All posts are returned, ordered by ID, if the query has no
+// words to search for.
+func (idx *postIndex) find(query string) []*Post {
+	q := parseSearchQuery(query)
+	if len(q) == 0 {
+		return idx.all()
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if !idx.loaded {
+		return nil
+	}
+
+	var posts []*Post
+	for _, id := range idx.search.search(q) {
+		posts = append(posts, clonePost(idx.posts[id]))
+	}
+	return posts
+}
+
+// resolveLink returns a copy of the post a wiki link target refers to.
+func (idx *postIndex) resolveLink(target string) (*Post, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if !idx.loaded {
+		return nil, false
+	}
+
+	id, ok := idx.links.resolve(target)
+	if !ok {
+		return nil, false
+	}
+	return clonePost(idx.posts[id]), true
+}
+
+// backlinks returns a copy of all posts linking to a post, ordered by ID.
+func (idx *postIndex) backlinks(id string) []*Post {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if !idx.loaded {
+		return nil
+	}
+
+	var posts []*Post
+	for _, src := range idx.links.backlinks(id) {
+		posts = append(posts, clonePost(idx.posts[src]))
+	}
+	return posts
+}
+
+// refresh re-reads a single post from disk, e.g. after it was changed by
+// another program.
+func (svc postsService) refresh(id string) {
+	p, err := svc.readPost(id)
+	if err == nil && p.ID != id {
+		err = fmt.Errorf("file has ID %q", p.ID)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		svc.index.remove(id)
+	} else if err != nil {
+		// Most likely a write in progress. Keep the last good version
+		// until the next change.
+		log.Printf("error: failed to refresh post %s: %v", id, err)
+	} else {
+		svc.index.set(p)
+	}
+}
+
+// Watch keeps the index in sync with changes made to the posts root by other
+// programs, until the returned io.Closer is closed.
+func (svc postsService) Watch() (io.Closer, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(svc.root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(ev.Name)
+				if strings.HasPrefix(name, ".") || ev.Op == fsnotify.Chmod {
+					continue
+				}
+				svc.refresh(postIDFromFilename(name))
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Events might have been lost
+				log.Printf("error: watching posts: %v", err)
+				svc.index.invalidate()
+			}
+		}
+	}()
+
+	return watcher, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestPostIndex(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir).(*postsService)
+
+	post := &Post{Title: "foo", Tags: []Tag{"a"}}
+	is.NoErr(svc.CreatePost(post))
+
+	t.Run("returned posts don't share memory with the index", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.GetPost(post.ID)
+		is.NoErr(err)
+		p.Title = "changed"
+		p.Tags[0] = "changed"
+
+		p, err = svc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "foo")
+		is.Equal(p.Tags, []Tag{"a"})
+	})
+
+	t.Run("posts added by other programs are found", func(t *testing.T) {
+		is := is.New(t)
+		other := &Post{Title: "other"}
+		is.NoErr(NewPostsService(tmpdir).CreatePost(other))
+
+		p, err := svc.GetPost(other.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "other")
+	})
+
+	t.Run("changes by other programs are picked up when watching", func(t *testing.T) {
+		is := is.New(t)
+
+		watcher, err := svc.Watch()
+		is.NoErr(err)
+		defer watcher.Close()
+
+		other := NewPostsService(tmpdir)
+		p, err := other.GetPost(post.ID)
+		is.NoErr(err)
+		p.Title = "edited elsewhere"
+		is.NoErr(other.UpdatePost(p))
+		is.NoErr(other.DeletePost(p.ID))
+
+		eventually(t, func() bool {
+			posts, err := svc.ListPosts(nil)
+			is.NoErr(err)
+			return len(posts) == 1
+		})
+
+		is.NoErr(other.RestorePost(p.ID))
+		eventually(t, func() bool {
+			p, err := svc.GetPost(post.ID)
+			return err == nil && p.Title == "edited elsewhere"
+		})
+	})
+
+	t.Run("invalidating reloads from disk", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(os.Remove(path.Join(tmpdir, post.ID)))
+		svc.index.invalidate()
+
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+	})
+}
+
+// eventually fails the test if cond does not return true within a second.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+// seedPosts creates n posts spread over a few tags and folders.
+func seedPosts(tb testing.TB, svc PostsService, n int) {
+	for i := 0; i < n; i++ {
+		err := svc.CreatePost(&Post{
+			Title:   fmt.Sprintf("title%d", i),
+			Content: fmt.Sprintf("content%d", i),
+			Tags: []Tag{
+				Tag(fmt.Sprintf("tag%d", i%50)),
+				Tag(fmt.Sprintf("_dir:/dir%d/sub%d", i%10, i%7)),
+			},
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListPosts(b *testing.B) {
+	tmpdir, err := os.MkdirTemp("", "posts")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir).(*postsService)
+	seedPosts(b, svc, 10000)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := svc.ListPosts(nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			svc.index.invalidate()
+			if _, err := svc.ListPosts(nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A WikiLink is a reference to another post in the content of a post, written
+// as `[[Post title]]` or `[[id|label]]`.
+type WikiLink struct {
+	// Title or ID of the linked post
+	Target string
+	// Text of the link. Defaults to the target.
+	Label string
+}
+
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]+))?\]\]`)
+
+// replaceWikiLinks replaces every wiki link in Markdown content with the
+// result of repl, which is also given the link as written. Links in code
+// blocks and code spans are left as is.
+func replaceWikiLinks(content string, repl func(link WikiLink, raw string) string) string {
+	replaceAll := func(s string) string {
+		return wikiLinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+			sm := wikiLinkPattern.FindStringSubmatch(m)
+			link := WikiLink{
+				Target: strings.TrimSpace(sm[1]),
+				Label:  strings.TrimSpace(sm[2]),
+			}
+			if link.Label == "" {
+				link.Label = link.Target
+			}
+			return repl(link, m)
+		})
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	var fence string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		if !strings.Contains(line, "[[") {
+			continue
+		}
+
+		// Every other part is inside a code span
+		parts := strings.Split(line, "`")
+		for j := 0; j < len(parts); j += 2 {
+			parts[j] = replaceAll(parts[j])
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+
+	return strings.Join(lines, "")
+}
+
+// ParseWikiLinks returns all wiki links in Markdown content.
+func ParseWikiLinks(content string) []WikiLink {
+	var links []WikiLink
+	replaceWikiLinks(content, func(link WikiLink, raw string) string {
+		links = append(links, link)
+		return ""
+	})
+	return links
+}
+
+// normalizeLinkTarget makes link targets match titles regardless of case and
+// spacing.
+func normalizeLinkTarget(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// linkIndex keeps track of post titles and the wiki links between posts.
+type linkIndex struct {
+	// Normalized title -> IDs of the posts with that title, in order
+	titles map[string][]string
+	// Post ID -> normalized title
+	docTitles map[string]string
+	// Post ID -> link targets
+	outbound map[string][]string
+	// Normalized link target -> IDs of the posts linking to it
+	inbound map[string]map[string]bool
+}
+
+func newLinkIndex() *linkIndex {
+	return &linkIndex{
+		titles:    make(map[string][]string),
+		docTitles: make(map[string]string),
+		outbound:  make(map[string][]string),
+		inbound:   make(map[string]map[string]bool),
+	}
+}
+
+// add indexes the title and links of a post, replacing any previously indexed
+// version.
+func (li *linkIndex) add(p *Post) {
+	li.remove(p.ID)
+
+	title := normalizeLinkTarget(p.Title)
+	ids := append(li.titles[title], p.ID)
+	sort.Strings(ids)
+	li.titles[title] = ids
+	li.docTitles[p.ID] = title
+
+	for _, link := range ParseWikiLinks(p.Content) {
+		li.outbound[p.ID] = append(li.outbound[p.ID], link.Target)
+
+		target := normalizeLinkTarget(link.Target)
+		if li.inbound[target] == nil {
+			li.inbound[target] = make(map[string]bool)
+		}
+		li.inbound[target][p.ID] = true
+	}
+}
+
+func (li *linkIndex) remove(id string) {
+	title, ok := li.docTitles[id]
+	if !ok {
+		return
+	}
+
+	ids := li.titles[title]
+	for i := range ids {
+		if ids[i] == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(li.titles, title)
+	} else {
+		li.titles[title] = ids
+	}
+	delete(li.docTitles, id)
+
+	for _, target := range li.outbound[id] {
+		target = normalizeLinkTarget(target)
+		delete(li.inbound[target], id)
+		if len(li.inbound[target]) == 0 {
+			delete(li.inbound, target)
+		}
+	}
+	delete(li.outbound, id)
+}
+
+// resolve returns the ID of the post a link target refers to. IDs take
+// precedence over titles, and the oldest post wins if several posts have the
+// same title.
+func (li *linkIndex) resolve(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	if _, ok := li.docTitles[target]; ok {
+		return target, true
+	}
+	if ids := li.titles[normalizeLinkTarget(target)]; len(ids) > 0 {
+		return ids[0], true
+	}
+	return "", false
+}
+
+// backlinks returns the IDs of the posts linking to a post, in order.
+func (li *linkIndex) backlinks(id string) []string {
+	title, ok := li.docTitles[id]
+	if !ok {
+		return nil
+	}
+
+	candidates := make(map[string]bool)
+	for _, target := range []string{normalizeLinkTarget(id), title} {
+		for src := range li.inbound[target] {
+			candidates[src] = true
+		}
+	}
+
+	var ids []string
+	for src := range candidates {
+		if src == id {
+			continue
+		}
+		// The link might refer to another post with the same title
+		for _, target := range li.outbound[src] {
+			if resolved, ok := li.resolve(target); ok && resolved == id {
+				ids = append(ids, src)
+				break
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// ResolveLink returns the post a wiki link target refers to, by ID or title.
+func (svc postsService) ResolveLink(target string) (*Post, error) {
+	if err := svc.index.load(svc); err != nil {
+		return nil, fmt.Errorf("ResolveLink: %w", err)
+	}
+	p, ok := svc.index.resolveLink(target)
+	if !ok {
+		return nil, fmt.Errorf("ResolveLink: %q: %w", target, fs.ErrNotExist)
+	}
+	return p, nil
+}
+
+// ListBacklinks returns all posts linking to a post.
+func (svc postsService) ListBacklinks(postID string) ([]*Post, error) {
+	if err := svc.index.load(svc); err != nil {
+		return nil, fmt.Errorf("ListBacklinks: %w", err)
+	}
+	return svc.index.backlinks(postID), nil
+}
+
+// retargetWikiLinks changes the target of all wiki links to oldTarget into
+// newTarget, keeping their labels. Links without a label are labeled
+// newLabel.
+func retargetWikiLinks(content, oldTarget, newTarget, newLabel string) string {
+	oldTarget = normalizeLinkTarget(oldTarget)
+	return replaceWikiLinks(content, func(link WikiLink, raw string) string {
+		if normalizeLinkTarget(link.Target) != oldTarget {
+			return raw
+		}
+		label := link.Label
+		if label == link.Target {
+			label = newLabel
+		}
+		if label == newTarget {
+			return "[[" + newTarget + "]]"
+		}
+		return "[[" + newTarget + "|" + label + "]]"
+	})
+}
+
+// A LinkRewrite is a change to the content of a post, keeping its links to
+// another post working after that post's title changed.
+type LinkRewrite struct {
+	// The linking post, with the rewritten content
+	Post       *Post
+	OldContent string
+}
+
+// Diff returns the changed lines of the rewrite.
+func (lr *LinkRewrite) Diff() []DiffLine {
+	var lines []DiffLine
+	for _, l := range DiffLines(lr.OldContent, lr.Post.Content) {
+		if !l.IsEqual() {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// PlanLinkRewrites returns the changes needed to keep links to a post by its
+// title working if its title is changed to newTitle. If another post already
+// has that title, the links are changed to link by ID instead, as they would
+// lead to the other post. Nothing is saved.
+func PlanLinkRewrites(svc PostsService, postID, newTitle string) ([]*LinkRewrite, error) {
+	post, err := svc.GetPost(postID)
+	if err != nil {
+		return nil, fmt.Errorf("PlanLinkRewrites: %w", err)
+	}
+	newTitle = strings.TrimSpace(newTitle)
+	if newTitle == "" || normalizeLinkTarget(newTitle) == normalizeLinkTarget(post.Title) {
+		return nil, nil
+	}
+
+	newTarget := newTitle
+	if other, err := svc.ResolveLink(newTitle); err == nil && other.ID != postID {
+		newTarget = postID
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("PlanLinkRewrites: %w", err)
+	}
+
+	backlinks, err := svc.ListBacklinks(postID)
+	if err != nil {
+		return nil, fmt.Errorf("PlanLinkRewrites: %w", err)
+	}
+
+	var rewrites []*LinkRewrite
+	for _, p := range backlinks {
+		content := retargetWikiLinks(p.Content, post.Title, newTarget, newTitle)
+		if content == p.Content {
+			// Linked by ID only
+			continue
+		}
+		rewrites = append(rewrites, &LinkRewrite{Post: p, OldContent: p.Content})
+		// Posts changed since are not overwritten
+		p.BaseVersion = p.Version()
+		p.Content = content
+	}
+
+	return rewrites, nil
+}
+
+// ErrLinksNotRewritten is returned by UpdatePostAndLinks when the post was
+// updated, but the links in some of the posts linking to it were not
+// rewritten.
+var ErrLinksNotRewritten = errors.New("the post was saved, but links in some posts were not rewritten")
+
+// A LinkRewriteError names the posts UpdatePostAndLinks failed to rewrite. It
+// matches ErrLinksNotRewritten.
+type LinkRewriteError struct {
+	// The posts, as they were before the rewrite
+	Posts []*Post
+	// Why each of the posts was not rewritten
+	Errs []error
+}
+
+func (e *LinkRewriteError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = fmt.Sprintf("%q: %v", e.Posts[i].Title, err)
+	}
+	return fmt.Sprintf("%v: %s", ErrLinksNotRewritten, strings.Join(msgs, "; "))
+}
+
+// Is makes the error match ErrLinksNotRewritten, but not the errors of the
+// posts, so they are not taken for ones of the updated post.
+func (e *LinkRewriteError) Is(target error) bool {
+	return target == ErrLinksNotRewritten
+}
+
+// UpdatePostAndLinks updates a post like UpdatePost, and rewrites the links
+// to its previous title in all posts linking to it. It returns the rewritten
+// posts. Posts that fail to be rewritten, like ones changed in the meantime,
+// do not stop the others from being rewritten. They are named in a
+// LinkRewriteError, which is returned along with the posts that were
+// rewritten.
+func UpdatePostAndLinks(svc PostsService, p *Post) ([]*Post, error) {
+	rewrites, err := PlanLinkRewrites(svc, p.ID, p.Title)
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePostAndLinks: %w", err)
+	}
+
+	if err := svc.UpdatePost(p); err != nil {
+		return nil, fmt.Errorf("UpdatePostAndLinks: %w", err)
+	}
+
+	var (
+		updated []*Post
+		failed  LinkRewriteError
+	)
+	for _, rw := range rewrites {
+		if err := svc.UpdatePost(rw.Post); err != nil {
+			rw.Post.Content = rw.OldContent
+			failed.Posts = append(failed.Posts, rw.Post)
+			failed.Errs = append(failed.Errs, err)
+			continue
+		}
+		updated = append(updated, rw.Post)
+	}
+	if len(failed.Posts) > 0 {
+		return updated, fmt.Errorf("UpdatePostAndLinks: %w", &failed)
+	}
+
+	return updated, nil
+}
+
+// LinkRewritesHandler previews the link rewrites of changing the title of a
+// post, as an HTML fragment for the edit form.
+func (app *App) LinkRewritesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		rewrites, err := PlanLinkRewrites(app.posts, postID, r.FormValue("title"))
+		if err != nil {
+			log.Printf("error: LinkRewritesHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 404)
+			return
+		}
+
+		if err := app.templates.ExecuteTemplate(w, "link-rewrites.html", rewrites); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
+
+// Prefix of links to create a missing post with the title given in the query
+const missingPostURL = "/posts/?title="
+
+// RenderContentHTML renders Markdown content as sanitized HTML. Wiki links
+// are turned into links to the posts they refer to, or into links creating
+// the post if missing.
+func RenderContentHTML(content string, posts PostsService) template.HTML {
+	content = replaceWikiLinks(content, func(link WikiLink, raw string) string {
+		p, err := posts.ResolveLink(link.Target)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("error: RenderContentHTML: %v", err)
+			}
+			return fmt.Sprintf(`[%s](%s%s "Missing post, click to create it")`,
+				link.Label, missingPostURL, url.QueryEscape(link.Target))
+		}
+		return fmt.Sprintf(`[%s](/posts/%s)`, link.Label, p.ID)
+	})
+
+	return renderMarkdown(content)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseWikiLinks(t *testing.T) {
+	is := is.New(t)
+
+	links := ParseWikiLinks(strings.Join([]string{
+		"See [[Post title]] and [[ 2Abc | the other one ]].",
+		"Not `[[in code]]`, but [[after code]]",
+		"```",
+		"[[in a code block]]",
+		"```",
+		"[[]] [[a|]] [[last]]",
+	}, "\n"))
+
+	is.Equal(links, []WikiLink{
+		{Target: "Post title", Label: "Post title"},
+		{Target: "2Abc", Label: "the other one"},
+		{Target: "after code", Label: "after code"},
+		{Target: "last", Label: "last"},
+	})
+}
+
+func TestLinks(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	target := &Post{Title: "Nginx  Config"}
+	is.NoErr(svc.CreatePost(target))
+	byTitle := &Post{Title: "a", Content: "See [[nginx config]]"}
+	is.NoErr(svc.CreatePost(byTitle))
+	byID := &Post{Title: "b", Content: "See [[" + target.ID + "|the config]] and [[Missing]]"}
+	is.NoErr(svc.CreatePost(byID))
+	is.NoErr(svc.CreatePost(&Post{Title: "c", Content: "No links to `[[Nginx Config]]`"}))
+
+	t.Run("resolve by title or ID", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.ResolveLink("NGINX config")
+		is.NoErr(err)
+		is.Equal(p.ID, target.ID)
+
+		p, err = svc.ResolveLink(target.ID)
+		is.NoErr(err)
+		is.Equal(p.ID, target.ID)
+
+		_, err = svc.ResolveLink("Missing")
+		is.True(errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("backlinks", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := svc.ListBacklinks(target.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.True(posts[0].ID < posts[1].ID) // ordered by ID
+		is.True(posts[0].ID == byTitle.ID || posts[1].ID == byTitle.ID)
+		is.True(posts[0].ID == byID.ID || posts[1].ID == byID.ID)
+	})
+
+	t.Run("backlinks follow title changes", func(t *testing.T) {
+		is := is.New(t)
+		target.Title = "Apache Config"
+		is.NoErr(svc.UpdatePost(target))
+
+		posts, err := svc.ListBacklinks(target.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(posts[0].ID, byID.ID)
+	})
+
+	t.Run("backlinks of deleted posts are gone", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(byID.ID))
+
+		posts, err := svc.ListBacklinks(target.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+	})
+
+	t.Run("render links", func(t *testing.T) {
+		is := is.New(t)
+		html := string(RenderContentHTML("[[Apache config|conf]] and [[Nope & co]]", svc))
+		is.True(strings.Contains(html, `<a href="/posts/`+target.ID+`" rel="nofollow">conf</a>`))
+		is.True(strings.Contains(html, `<a href="/posts/?title=Nope+%26+co" title="Missing post, click to create it" rel="nofollow">Nope &amp; co</a>`))
+	})
+}
+
+func TestRetargetWikiLinks(t *testing.T) {
+	is := is.New(t)
+
+	content := "[[Old]] [[ old |label]] [[Other]] `[[Old]]`\n[[old]]"
+	is.Equal(retargetWikiLinks(content, "OLD", "New", "New"), "[[New]] [[New|label]] [[Other]] `[[Old]]`\n[[New]]")
+	is.Equal(retargetWikiLinks(content, "OLD", "id", "New"), "[[id|New]] [[id|label]] [[Other]] `[[Old]]`\n[[id|New]]")
+}
+
+func TestUpdatePostAndLinks(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	target := &Post{Title: "Old Title"}
+	is.NoErr(svc.CreatePost(target))
+	byTitle := &Post{Title: "a", Content: "See [[old title]]\nand [[Old Title|this]]"}
+	is.NoErr(svc.CreatePost(byTitle))
+	byID := &Post{Title: "b", Content: "See [[" + target.ID + "]]"}
+	is.NoErr(svc.CreatePost(byID))
+
+	t.Run("plan is a dry run", func(t *testing.T) {
+		is := is.New(t)
+		rewrites, err := PlanLinkRewrites(svc, target.ID, "New Title")
+		is.NoErr(err)
+		is.Equal(len(rewrites), 1)
+		is.Equal(rewrites[0].Post.ID, byTitle.ID)
+		is.Equal(rewrites[0].Post.Content, "See [[New Title]]\nand [[New Title|this]]")
+		is.Equal(len(rewrites[0].Diff()), 4)
+
+		p, err := svc.GetPost(byTitle.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, byTitle.Content)
+	})
+
+	t.Run("no rewrites without a title change", func(t *testing.T) {
+		is := is.New(t)
+		rewrites, err := PlanLinkRewrites(svc, target.ID, "old title")
+		is.NoErr(err)
+		is.Equal(len(rewrites), 0)
+	})
+
+	t.Run("update rewrites links", func(t *testing.T) {
+		is := is.New(t)
+		target.Title = "New Title"
+		updated, err := UpdatePostAndLinks(svc, target)
+		is.NoErr(err)
+		is.Equal(len(updated), 1)
+		is.Equal(updated[0].ID, byTitle.ID)
+
+		p, err := svc.GetPost(byTitle.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "See [[New Title]]\nand [[New Title|this]]")
+
+		posts, err := svc.ListBacklinks(target.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+	})
+
+	t.Run("links to a title another post has are changed to the ID", func(t *testing.T) {
+		is := is.New(t)
+		taken := &Post{Title: "Taken"}
+		is.NoErr(svc.CreatePost(taken))
+
+		target.Title = "Taken"
+		updated, err := UpdatePostAndLinks(svc, target)
+		is.NoErr(err)
+		is.Equal(len(updated), 1)
+		is.Equal(updated[0].Content, "See [["+target.ID+"|Taken]]\nand [["+target.ID+"|this]]")
+
+		posts, err := svc.ListBacklinks(target.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		posts, err = svc.ListBacklinks(taken.ID)
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+	})
+
+	t.Run("failed rewrites do not stop the others", func(t *testing.T) {
+		is := is.New(t)
+		renamed := &Post{Title: "Renamed"}
+		is.NoErr(svc.CreatePost(renamed))
+		failing := &Post{Title: "d", Content: "[[Renamed]]"}
+		is.NoErr(svc.CreatePost(failing))
+		other := &Post{Title: "e", Content: "[[Renamed]]"}
+		is.NoErr(svc.CreatePost(other))
+
+		renamed.Title = "Renamed again"
+		updated, err := UpdatePostAndLinks(failingUpdates{svc, failing.ID}, renamed)
+		is.True(errors.Is(err, ErrLinksNotRewritten))
+		is.True(strings.Contains(err.Error(), `"d"`))
+		is.Equal(len(updated), 1)
+		is.Equal(updated[0].ID, other.ID)
+
+		p, err := svc.GetPost(renamed.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "Renamed again")
+
+		// The edit form shows which posts were not rewritten
+		failing.Content = "[[Renamed again]]"
+		is.NoErr(svc.UpdatePost(failing))
+		app := NewApp(failingUpdates{svc, failing.ID}, ":1337")
+		form := url.Values{"title": {"Renamed once more"}, "rewriteLinks": {"1"}}
+		r := httptest.NewRequest(http.MethodPost, "/posts/"+renamed.ID, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		is.Equal(w.Code, http.StatusSeeOther)
+		loc := w.Header().Get("Location")
+		is.Equal(loc, "/posts/"+renamed.ID+"?"+url.Values{"notRelinked": {failing.ID}, "relinked": {other.ID}}.Encode())
+
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, loc, nil))
+		is.True(strings.Contains(w.Body.String(), "could not be updated"))
+	})
+}
+
+// failingUpdates is a PostsService failing to update one of its posts.
+type failingUpdates struct {
+	PostsService
+	postID string
+}
+
+func (svc failingUpdates) UpdatePost(p *Post) error {
+	if p.ID == svc.postID {
+		return errors.New("disk full")
+	}
+	return svc.PostsService.UpdatePost(p)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -9,19 +10,25 @@ import (
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/html"
-	"github.com/microcosm-cc/bluemonday"
+	"time"
 )
 
 var (
-	dataDir    string
-	listenAddr string
+	dataDir         string
+	listenAddr      string
+	trashRetention  time.Duration
+	storageBackend  string
+	gitRemote       string
+	gitSyncInterval time.Duration
+	postFormat      string
+	maxAttachment   int64
 
 	//go:embed templates/*.html
 	templateFS embed.FS
@@ -39,13 +46,123 @@ func init() {
 
 	flag.StringVar(&listenAddr, "listen-addr", ":8080", "HTTP listen address")
 	flag.StringVar(&dataDir, "root", defaultDataDir, "filepath to store app data")
+	flag.DurationVar(&trashRetention, "trash-retention", 30*24*time.Hour, "time to keep deleted posts before purging them (0 keeps them forever)")
+	flag.StringVar(&storageBackend, "storage", "file", "storage backend for posts (file, git, sqlite)")
+	flag.StringVar(&gitRemote, "git-remote", "", "URL or path of a git repository to sync posts with (git storage only)")
+	flag.DurationVar(&gitSyncInterval, "git-sync-interval", 5*time.Minute, "how often to sync posts with the git remote")
+	flag.StringVar(&postFormat, "format", string(FormatJSON), "file format of new and updated posts (json, markdown)")
+	flag.Int64Var(&maxAttachment, "max-attachment-size", DefaultMaxAttachmentSize, "maximum size of a file attached to a post, in bytes")
 }
 
 func main() {
 	flag.Parse()
 
 	mustCreateDataDir(dataDir)
-	app := NewApp(dataDir, listenAddr)
+
+	format := PostFormat(postFormat)
+	if format != FormatJSON && format != FormatMarkdown {
+		log.Fatalf("unknown post format '%s'", postFormat)
+	}
+
+	var postsSvc PostsService
+	switch storageBackend {
+	case "file":
+		postsSvc = NewPostsServiceWithFormat(dataDir, format)
+	case "git":
+		svc, err := NewGitPostsService(dataDir, format, gitRemote)
+		if err != nil {
+			log.Fatalf("failed to open git storage: %v", err)
+		}
+		if gitRemote != "" && gitSyncInterval > 0 {
+			go svc.syncPeriodically(gitSyncInterval)
+		}
+		postsSvc = svc
+	case "sqlite":
+		svc, err := NewSQLitePostsService(path.Join(dataDir, SQLiteFileName))
+		if err != nil {
+			log.Fatalf("failed to open sqlite storage: %v", err)
+		}
+		postsSvc = svc
+	default:
+		log.Fatalf("unknown storage backend '%s'", storageBackend)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "convert-to-markdown":
+		converter, ok := postsSvc.(interface{ ConvertToMarkdown() (int, error) })
+		if !ok {
+			log.Fatalf("storage backend '%s' does not support %s", storageBackend, cmd)
+		}
+		n, err := converter.ConvertToMarkdown()
+		if err != nil {
+			log.Fatalf("failed to convert posts: %v", err)
+		}
+		log.Printf("Converted %d posts to Markdown", n)
+		return
+	case "migrate":
+		migrateFlags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		to := migrateFlags.String("to", "sqlite", "storage backend to copy all posts to from the other one (file, sqlite)")
+		migrateFlags.Parse(flag.Args()[1:])
+
+		db, err := NewSQLitePostsService(path.Join(dataDir, SQLiteFileName))
+		if err != nil {
+			log.Fatalf("failed to open sqlite storage: %v", err)
+		}
+		defer db.Close()
+		files := NewPostsServiceWithFormat(dataDir, format).(*postsService)
+
+		var n int
+		switch *to {
+		case "sqlite":
+			n, err = MigratePosts(files, db)
+		case "file":
+			n, err = MigratePosts(db, files)
+		default:
+			log.Fatalf("unknown storage backend '%s'", *to)
+		}
+		if err != nil {
+			log.Fatalf("failed to migrate posts: %v", err)
+		}
+		log.Printf("Copied %d posts to %s storage", n, *to)
+		return
+	case "fsck":
+		fscker, ok := postsSvc.(Fscker)
+		if !ok {
+			log.Fatalf("storage backend '%s' does not support %s", storageBackend, cmd)
+		}
+		fsckFlags := flag.NewFlagSet(cmd, flag.ExitOnError)
+		quarantine := fsckFlags.Bool("quarantine", false, "move broken files to the quarantine directory")
+		fsckFlags.Parse(flag.Args()[1:])
+
+		report, err := fscker.Fsck(*quarantine)
+		if err != nil {
+			log.Fatalf("failed to check posts: %v", err)
+		}
+		for _, issue := range report.Issues {
+			fmt.Printf("%s: %s: %s\n", issue.Name, issue.Problem, issue.Detail)
+			if issue.Quarantined != "" {
+				fmt.Printf("  moved to %s\n", issue.Quarantined)
+			}
+		}
+		log.Printf("Checked %d posts, %d problems", report.Posts, len(report.Issues))
+		if report.Unresolved() > 0 {
+			os.Exit(1)
+		}
+		return
+	default:
+		log.Fatalf("unknown command '%s'", cmd)
+	}
+
+	if watcher, ok := postsSvc.(interface{ Watch() (io.Closer, error) }); ok {
+		if _, err := watcher.Watch(); err != nil {
+			log.Printf("error: failed to watch datadir for changes: %v", err)
+		}
+	}
+
+	app := NewApp(postsSvc, listenAddr)
+	app.trashRetention = trashRetention
+	app.attachments = NewAttachmentStore(dataDir, maxAttachment)
 
 	posts, err := app.posts.ListPosts(nil)
 	if err != nil {
@@ -53,6 +170,8 @@ func main() {
 	}
 	log.Printf("Using datadir '%s' with %d posts", dataDir, len(posts))
 
+	go app.purgeTrashPeriodically(time.Hour)
+
 	// TODO: configure server params
 	log.Println("Starting HTTP server on", app.listenAddr)
 	panic(http.ListenAndServe(app.listenAddr, app))
@@ -72,13 +191,18 @@ type App struct {
 	router    *Router
 	templates *template.Template
 	posts     PostsService
+
+	// How long deleted posts are kept in the trash
+	trashRetention time.Duration
+	// Files attached to posts. Attachments are disabled if nil.
+	attachments *AttachmentStore
 }
 
-func NewApp(postsRoot, listenAddr string) *App {
+func NewApp(posts PostsService, listenAddr string) *App {
 	app := &App{
 		listenAddr: listenAddr,
 		router:     &Router{},
-		posts:      NewPostsService(postsRoot),
+		posts:      posts,
 	}
 
 	// Templates
@@ -95,9 +219,45 @@ func NewApp(postsRoot, listenAddr string) *App {
 	app.router.Post("^/posts/?$", app.PostHandler())
 	app.router.Get(`^/posts/(?P<id>\w+)$`, app.PostHandler())
 	app.router.Post(`^/posts/(?P<id>\w+)$`, app.PostHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/delete$`, app.DeletePostHandler())
+	app.router.Get(`^/posts/(?P<id>\w+)/history$`, app.HistoryHandler())
+	app.router.Get(`^/posts/(?P<id>\w+)/diff$`, app.DiffHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/revisions/(?P<rev>\w+)/restore$`, app.RestoreRevisionHandler())
+	app.router.Get(`^/posts/(?P<id>\w+)/link-rewrites$`, app.LinkRewritesHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/move$`, app.MovePostHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/state$`, app.TogglePostStateHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/attachments$`, app.UploadAttachmentsHandler())
+	app.router.Get(`^/posts/(?P<id>\w+)/attachments/(?P<name>[^/]+)$`, app.AttachmentHandler())
+	app.router.Post(`^/posts/(?P<id>\w+)/attachments/(?P<name>[^/]+)/delete$`, app.DeleteAttachmentHandler())
+
+	app.router.Get(`^/dirs(?:/(?P<path>.*))?$`, app.DirHandler())
+	app.router.Post(`^/dirs/(?P<path>.+)$`, app.ChangeDirHandler())
+	app.router.Post(`^/tree/move$`, app.TreeMoveHandler())
+
+	app.router.Get(`^/tags/?$`, app.TagsHandler())
+	app.router.Post(`^/tags/?$`, app.ChangeTagsHandler())
+
+	app.router.Get(`^/trash/?$`, app.TrashHandler())
+	app.router.Post(`^/trash/(?P<id>\w+)/restore$`, app.RestorePostHandler())
+	app.router.Post(`^/trash/(?P<id>\w+)/purge$`, app.PurgePostHandler())
+
+	app.router.Get(`^/fsck$`, app.FsckHandler())
+	app.router.Post(`^/fsck$`, app.FsckHandler())
 
 	app.router.Post(`^/render-markdown$`, app.RenderMarkdownHandler())
 
+	// JSON API
+	app.router.Get(`^`+APIPrefix+`/openapi.json$`, app.OpenAPIHandler())
+	app.router.Get(`^`+APIPrefix+`/posts/?$`, app.APIListPostsHandler())
+	app.router.Post(`^`+APIPrefix+`/posts/?$`, app.APICreatePostHandler())
+	app.router.Get(`^`+APIPrefix+`/posts/(?P<id>\w+)$`, app.APIGetPostHandler())
+	app.router.Put(`^`+APIPrefix+`/posts/(?P<id>\w+)$`, app.APIUpdatePostHandler(false))
+	app.router.Patch(`^`+APIPrefix+`/posts/(?P<id>\w+)$`, app.APIUpdatePostHandler(true))
+	app.router.Delete(`^`+APIPrefix+`/posts/(?P<id>\w+)$`, app.APIDeletePostHandler())
+	app.router.Get(`^`+APIPrefix+`/tags/?$`, app.APIListTagsHandler())
+	app.router.Get(`^`+APIPrefix+`/tree/?$`, app.APIFolderTreeHandler())
+	app.router.Any(`^`+APIPrefix+`(?:/|$)`, app.APINotFoundHandler())
+
 	return app
 }
 
@@ -108,7 +268,12 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type Globals struct {
 	PostsTree *Node
-	AllTags   []Tag
+	// Tag hierarchy, with the posts having each tag
+	TagsTree *Node
+	// Posts to create new posts from
+	Templates []*Post
+	// The current search query
+	Query string
 }
 
 type Locals struct {
@@ -120,54 +285,230 @@ func (app *App) buildLocals(extra any) *Locals {
 	postsTree, err := app.posts.GetPostsFolderTree()
 	if err != nil {
 		log.Printf("error: failed to get posts folder tree: %v", err)
+	} else {
+		postsTree = postsTree.Filter(notArchived)
 	}
 
-	tags, err := app.posts.ListTags(&ListTagOptions{IgnoreFunctional: true})
-	if err != nil {
+	var tagsTree *Node
+	if posts, err := app.posts.ListPosts(nil); err != nil {
 		log.Printf("error: failed to get tags: %v", err)
-		tags = nil
+	} else {
+		tagsTree = BuildTagTree(posts, &ListTagOptions{IgnoreFunctional: true})
+	}
+
+	templates, err := ListTemplates(app.posts)
+	if err != nil {
+		log.Printf("error: failed to get templates: %v", err)
 	}
 
 	return &Locals{
 		Globals: Globals{
 			PostsTree: postsTree,
-			AllTags:   tags,
+			TagsTree:  tagsTree,
+			Templates: templates,
 		},
 		Locals: extra,
 	}
 }
 
+// A post in a list of posts, with an excerpt of the content matching the
+// search query, if any.
+type PostListItem struct {
+	*Post
+	Snippet template.HTML
+}
+
+// Number of posts per page in post listings, unless another limit is given
+const (
+	PostsPageSize    = 50
+	MaxPostsPageSize = 1000
+)
+
+// listOptionsFromRequest builds post list options from the search query and
+// the listing parameters of a request.
+func listOptionsFromRequest(r *http.Request) (*ListPostOptions, error) {
+	opts, err := ParseQuery(r.FormValue("q"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Narrow down by all tags, unless asked otherwise
+	match := r.FormValue("match")
+	if match == "" {
+		match = string(TagMatchAll)
+	}
+	if opts.TagMatch, err = ParseTagMatchMode(match); err != nil {
+		return nil, err
+	}
+
+	// Tags can also be given as a comma separated list
+	if q := r.FormValue("tags"); len(q) > 0 {
+		for _, t := range strings.Split(q, ",") {
+			if len(t) > 0 {
+				opts.TagsFilter = append(opts.TagsFilter, t)
+			}
+		}
+	}
+
+	// Archived posts are only listed when asked for, or filtered on
+	archived, err := boolFormValue(r, "archived")
+	if err != nil {
+		return nil, err
+	}
+	opts.ExcludeArchived = !archived && !slices.Contains(opts.TagsFilter, string(ArchivedTag))
+
+	if opts.SortBy, err = ParsePostSortKey(r.FormValue("sort")); err != nil {
+		return nil, err
+	}
+	switch order := r.FormValue("order"); order {
+	case "asc":
+	case "desc":
+		opts.SortDescending = true
+	case "":
+		// Newest first, but titles alphabetically
+		opts.SortDescending = opts.SortBy != SortByTitle
+	default:
+		return nil, fmt.Errorf("invalid sort order %q", order)
+	}
+
+	if s := r.FormValue("offset"); s != "" {
+		if opts.Offset, err = strconv.Atoi(s); err != nil || opts.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", s)
+		}
+	}
+	opts.Limit = PostsPageSize
+	if s := r.FormValue("limit"); s != "" {
+		if opts.Limit, err = strconv.Atoi(s); err != nil || opts.Limit < 1 || opts.Limit > MaxPostsPageSize {
+			return nil, fmt.Errorf("invalid limit %q, must be between 1 and %d", s, MaxPostsPageSize)
+		}
+	}
+
+	return opts, nil
+}
+
+// postsFromQuery returns the posts listed by their IDs, separated by commas,
+// in a query parameter. IDs of missing posts are skipped.
+func (app *App) postsFromQuery(r *http.Request, name string) []*Post {
+	var posts []*Post
+	q := r.URL.Query().Get(name)
+	if q == "" {
+		return nil
+	}
+	for _, id := range strings.Split(q, ",") {
+		if !plainIDPattern.MatchString(id) {
+			continue
+		}
+		if p, err := app.posts.GetPost(id); err == nil {
+			posts = append(posts, p)
+		}
+	}
+	return posts
+}
+
+// joinPostIDs returns the IDs of posts, separated by commas.
+func joinPostIDs(posts []*Post) string {
+	ids := make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// boolFormValue parses an optional boolean form value, which is false if left
+// out.
+func boolFormValue(r *http.Request, name string) (bool, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, must be true or false", name, s)
+	}
+	return v, nil
+}
+
+// pageURL returns the URL of the request with a different offset.
+func pageURL(r *http.Request, offset int) string {
+	q := r.URL.Query()
+	q.Set("offset", strconv.Itoa(offset))
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// paramURL returns the URL of the request with a query parameter set to
+// another value, back on the first page.
+func paramURL(r *http.Request, name, value string) string {
+	q := r.URL.Query()
+	q.Set(name, value)
+	q.Del("offset")
+	return r.URL.Path + "?" + q.Encode()
+}
+
 func (app *App) IndexHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		searchQ := r.FormValue("q")
 
-		var searchTags []string
-		if q := r.FormValue("tags"); len(q) > 0 {
-			for _, t := range strings.Split(q, ",") {
-				if len(t) > 0 {
-					searchTags = append(searchTags, t)
-				}
+		var (
+			posts   []*Post
+			hasMore bool
+		)
+		opts, queryErr := listOptionsFromRequest(r)
+		if queryErr == nil {
+			// Fetch an extra post to tell if there is a next page
+			opts.Limit++
+			var err error
+			posts, err = app.posts.ListPosts(opts)
+			if err != nil {
+				log.Printf("error: failed to list posts: %v", err)
+				return
+			}
+			opts.Limit--
+
+			if len(posts) > opts.Limit {
+				posts, hasMore = posts[:opts.Limit], true
 			}
 		}
-		// Post content filter
-		posts, err := app.posts.ListPosts(&ListPostOptions{
-			SearchTerm: searchQ,
-			TagsFilter: searchTags,
-		})
-		if err != nil {
-			log.Printf("error: failed to list posts: %v", err)
-			return
+
+		items := make([]PostListItem, len(posts))
+		for i, p := range posts {
+			items[i].Post = p
+			if opts.SearchTerm != "" {
+				items[i].Snippet = Snippet(p.Content, opts.SearchTerm)
+			}
+		}
+
+		// Switching the tag match mode keeps the rest of the query
+		matchURLs := make(map[string]string)
+		for _, m := range []TagMatchMode{TagMatchAll, TagMatchAny, TagMatchNone} {
+			matchURLs[string(m)] = paramURL(r, "match", string(m))
+		}
+
+		var prevURL, nextURL string
+		if opts != nil {
+			if opts.Offset > 0 {
+				prevURL = pageURL(r, max(0, opts.Offset-opts.Limit))
+			}
+			if hasMore {
+				nextURL = pageURL(r, opts.Offset+opts.Limit)
+			}
 		}
 
 		locals := app.buildLocals(struct {
-			Posts        []*Post
-			ContentQuery string
-			TagQuery     []string
+			Posts      []PostListItem
+			Query      *ListPostOptions
+			QueryError error
+			PrevURL    string
+			NextURL    string
+			MatchURLs  map[string]string
 		}{
-			Posts:        posts,
-			ContentQuery: searchQ,
-			TagQuery:     searchTags,
+			Posts:      items,
+			Query:      opts,
+			QueryError: queryErr,
+			PrevURL:    prevURL,
+			NextURL:    nextURL,
+			MatchURLs:  matchURLs,
 		})
+		locals.Globals.Query = searchQ
 
 		if err := app.templates.ExecuteTemplate(w, "index.html", locals); err != nil {
 			log.Printf("error: template: %v", err)
@@ -176,13 +517,34 @@ func (app *App) IndexHandler() http.HandlerFunc {
 }
 
 func (app *App) PostHandler() http.HandlerFunc {
+	// JSON requests are handled like their API counterparts
+	apiList := app.APIListPostsHandler()
+	apiGet := app.APIGetPostHandler()
+	apiCreate := app.APICreatePostHandler()
+	apiUpdate := app.APIUpdatePostHandler(false)
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		postID, hasID := r.Context().Value("id").(string)
+		if wantsJSON(r) || (r.Method == http.MethodPost && isJSONRequest(r)) {
+			switch {
+			case r.Method == http.MethodGet && hasID:
+				apiGet(w, r)
+			case r.Method == http.MethodGet:
+				apiList(w, r)
+			case hasID:
+				apiUpdate(w, r)
+			default:
+				apiCreate(w, r)
+			}
+			return
+		}
+
 		var (
 			post *Post
 			err  error
 		)
 
-		if postID, ok := r.Context().Value("id").(string); ok {
+		if hasID {
 			post, err = app.posts.GetPost(postID)
 			if err != nil {
 				log.Printf("error: PostHandler: %v", err)
@@ -190,16 +552,73 @@ func (app *App) PostHandler() http.HandlerFunc {
 				return
 			}
 		} else {
-			post = new(Post)
+			var (
+				tmpl  *Post
+				ready bool
+			)
+			post, tmpl, ready, err = app.newPostFromRequest(r)
+			if err != nil {
+				log.Printf("error: PostHandler: %v", err)
+				http.Error(w, fmt.Sprintf("%v", err), 404)
+				return
+			}
+			if !ready {
+				app.renderTemplateFields(w, r, tmpl)
+				return
+			}
 		}
 
 		if r.Method == http.MethodGet {
+			var backlinks []*Post
+			if post.ID != "" {
+				if backlinks, err = app.posts.ListBacklinks(post.ID); err != nil {
+					log.Printf("error: PostHandler: %v", err)
+				}
+			}
+
+			// Posts whose links were rewritten by the last update, or failed
+			// to be
+			relinked := app.postsFromQuery(r, "relinked")
+			notRelinked := app.postsFromQuery(r, "notRelinked")
+
+			var attachments []*Attachment
+			if post.ID != "" && app.attachments != nil {
+				if attachments, err = app.attachments.List(post.ID); err != nil {
+					log.Printf("error: PostHandler: %v", err)
+				}
+			}
+
+			var folders [][]Breadcrumb
+			for _, dir := range post.Dirs() {
+				if crumbs := Breadcrumbs(dir); crumbs != nil {
+					folders = append(folders, crumbs)
+				}
+			}
+
 			locals := app.buildLocals(struct {
-				Post      *Post
+				Post        *Post
+				ContentHTML template.HTML
+				Backlinks   []*Post
+				Relinked    []*Post
+				NotRelinked []*Post
+				Attachments []*Attachment
+				// Whether files can be attached to posts
+				CanAttach bool
+				// Breadcrumbs of each folder the post is in
+				Folders   [][]Breadcrumb
+				States    []PostState
 				IsEditing bool
 			}{
-				Post:      post,
-				IsEditing: post.ID == "" || r.URL.Query().Has("isEditing"),
+				Post:        post,
+				ContentHTML: RenderContentHTML(post.Content, app.posts),
+				Backlinks:   backlinks,
+				Folders:     folders,
+				States:      postStates(post),
+				Relinked:    relinked,
+				NotRelinked: notRelinked,
+				Attachments: attachments,
+				CanAttach:   app.attachments != nil,
+				IsEditing:   post.ID == "" || r.URL.Query().Has("isEditing"),
 			})
 			if err := app.templates.ExecuteTemplate(w, "post.html", locals); err != nil {
 				log.Printf("error: template: %v", err)
@@ -214,6 +633,8 @@ func (app *App) PostHandler() http.HandlerFunc {
 					post.Tags = append(post.Tags, Tag(s))
 				}
 			}
+			// Edits of a post that was changed since are not saved
+			post.BaseVersion = r.FormValue("version")
 
 			if post.ID == "" {
 				if err := app.posts.CreatePost(post); err != nil {
@@ -221,8 +642,36 @@ func (app *App) PostHandler() http.HandlerFunc {
 					http.Error(w, fmt.Sprintf("%v", err), 400)
 					return
 				}
+			} else if r.FormValue("rewriteLinks") != "" {
+				relinked, err := UpdatePostAndLinks(app.posts, post)
+				var notRelinked *LinkRewriteError
+				if errors.Is(err, ErrConflict) {
+					app.renderConflict(w, post)
+					return
+				} else if errors.As(err, &notRelinked) {
+					// The post itself was saved
+					log.Printf("error: PostHandler: %v", err)
+				} else if err != nil {
+					log.Printf("error: PostHandler: %v", err)
+					http.Error(w, fmt.Sprintf("%v", err), 400)
+					return
+				}
+				q := make(url.Values)
+				if len(relinked) > 0 {
+					q.Set("relinked", joinPostIDs(relinked))
+				}
+				if notRelinked != nil {
+					q.Set("notRelinked", joinPostIDs(notRelinked.Posts))
+				}
+				if len(q) > 0 {
+					http.Redirect(w, r, "/posts/"+post.ID+"?"+q.Encode(), http.StatusSeeOther)
+					return
+				}
 			} else {
-				if err := app.posts.UpdatePost(post); err != nil {
+				if err := app.posts.UpdatePost(post); errors.Is(err, ErrConflict) {
+					app.renderConflict(w, post)
+					return
+				} else if err != nil {
 					log.Printf("error: PostHandler: %v", err)
 					http.Error(w, fmt.Sprintf("%v", err), 400)
 					return
@@ -283,15 +732,9 @@ func (app *App) StaticHandler(next http.Handler) http.Handler {
 }
 
 func (app *App) RenderMarkdownHandler() http.HandlerFunc {
-	renderer := html.NewRenderer(
-		html.RendererOptions{Flags: html.CommonFlags | html.HrefTargetBlank},
-	)
-	bm := bluemonday.UGCPolicy()
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		md := r.FormValue("content")
-		s := string(markdown.ToHTML([]byte(md), nil, renderer))
-		s = bm.Sanitize(s)
+		s := RenderContentHTML(md, app.posts)
 		w.Header().Set("Content-Type", "text/html")
 		fmt.Fprintf(w, "%s", s)
 	}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/matryer/is"
@@ -16,11 +17,7 @@ func TestHTTP(t *testing.T) {
 	is := is.New(t)
 
 	// Setup test app
-	dir, err := os.MkdirTemp("", "")
-	is.NoErr(err)
-	defer os.RemoveAll(dir)
-
-	app := NewApp(dir, ":1337")
+	app := NewApp(NewMemoryPostsService(), ":1337")
 
 	// Seed app with posts
 	var posts []*Post
@@ -45,6 +42,26 @@ func TestHTTP(t *testing.T) {
 			http.MethodGet, "/", nil,
 			200,
 		},
+		{
+			http.MethodGet, "/?q=tag:tag1+-tag:tag2+created:<1d+content1", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/?q=created:tomorrow", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/?q=tag:tag1+tag:tag2&match=any", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/?sort=title&order=asc&offset=1", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/?sort=size&offset=-1", nil,
+			200,
+		},
 		//
 		// Posts
 		//
@@ -68,13 +85,59 @@ func TestHTTP(t *testing.T) {
 			http.MethodPost, "/posts/", []byte(`{"title": "Foo", "content": "Bar", "tags": ["foo", "bar"]}`),
 			201,
 		},
+		{
+			http.MethodGet, "/posts/" + posts[0].ID + "/link-rewrites?title=foo", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/posts/nope/link-rewrites?title=foo", nil,
+			404,
+		},
+		//
+		// Revisions
+		//
+		{
+			http.MethodGet, "/posts/" + posts[0].ID + "/history", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/posts/" + posts[0].ID + "/diff?from=current", nil,
+			200,
+		},
+		{
+			http.MethodGet, "/posts/" + posts[0].ID + "/diff?from=nope", nil,
+			404,
+		},
+		{
+			http.MethodPost, "/posts/" + posts[0].ID + "/revisions/nope/restore", nil,
+			400,
+		},
+		//
+		// Trash
+		//
+		{
+			http.MethodPost, "/posts/" + posts[9].ID + "/delete", nil,
+			303,
+		},
+		{
+			http.MethodGet, "/trash", nil,
+			200,
+		},
+		{
+			http.MethodPost, "/trash/" + posts[9].ID + "/restore", nil,
+			303,
+		},
+		{
+			http.MethodPost, "/trash/" + posts[9].ID + "/purge", nil,
+			400,
+		},
 		//
 		// Tags
 		//
-		//{
-		//	http.MethodGet, "/tags/", nil,
-		//	200,
-		//},
+		{
+			http.MethodGet, "/tags/", nil,
+			200,
+		},
 	}
 
 	for i, tc := range testCases {
@@ -94,3 +157,38 @@ func TestHTTP(t *testing.T) {
 		})
 	}
 }
+
+// Measures the latency of the index page, which lists all posts, tags and
+// the folder tree.
+func TestIndexMatchLinks(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	is.NoErr(app.posts.CreatePost(&Post{Title: "a", Tags: []Tag{"x", "y"}}))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?q=tag:x+tag:y&match=all&sort=title&archived=1&offset=20", nil))
+	is.Equal(w.Code, 200)
+	is.True(strings.Contains(w.Body.String(), `href="/?archived=1&amp;match=none&amp;q=tag%3Ax&#43;tag%3Ay&amp;sort=title"`))
+}
+
+func BenchmarkIndexHandler(b *testing.B) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	app := NewApp(NewPostsService(dir), ":1337")
+	seedPosts(b, app.posts, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		if w.Code != 200 {
+			b.Fatalf("unexpected status %d", w.Code)
+		}
+	}
+}
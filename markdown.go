@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PostFormat is the on-disk format of a post file.
+type PostFormat string
+
+const (
+	// A JSON encoded Post, in a file named by the post ID
+	FormatJSON PostFormat = "json"
+	// Markdown content with YAML front matter, in a file named by the post
+	// ID with the MarkdownExt extension.
+	FormatMarkdown PostFormat = "markdown"
+)
+
+const MarkdownExt = ".md"
+
+const frontMatterDelim = "---\n"
+
+// frontMatter holds the fields of a Post stored in the YAML front matter of
+// a Markdown post file.
+type frontMatter struct {
+	ID       string    `yaml:"id"`
+	Title    string    `yaml:"title"`
+	Tags     []Tag     `yaml:"tags,flow"`
+	Created  time.Time `yaml:"created"`
+	Modified time.Time `yaml:"modified"`
+}
+
+func marshalMarkdown(p *Post) ([]byte, error) {
+	fm, err := yaml.Marshal(frontMatter{
+		ID:       p.ID,
+		Title:    p.Title,
+		Tags:     p.Tags,
+		Created:  p.CreatedTime,
+		Modified: p.ModifiedTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(frontMatterDelim)
+	buf.Write(fm)
+	buf.WriteString(frontMatterDelim)
+	buf.WriteString("\n")
+	buf.WriteString(p.Content)
+
+	return buf.Bytes(), nil
+}
+
+func unmarshalMarkdown(b []byte, p *Post) error {
+	s := strings.ReplaceAll(string(b), "\r\n", "\n")
+	if !strings.HasPrefix(s, frontMatterDelim) {
+		return errors.New("missing front matter")
+	}
+	s = strings.TrimPrefix(s, frontMatterDelim)
+
+	end := strings.Index(s, "\n"+frontMatterDelim)
+	if end < 0 {
+		return errors.New("unterminated front matter")
+	}
+
+	var fm frontMatter
+	if err := yaml.Unmarshal([]byte(s[:end+1]), &fm); err != nil {
+		return fmt.Errorf("front matter: %w", err)
+	}
+
+	p.ID = fm.ID
+	p.Title = fm.Title
+	p.Tags = fm.Tags
+	p.CreatedTime = fm.Created
+	p.ModifiedTime = fm.Modified
+	// A single blank line separates the front matter from the content
+	p.Content = strings.TrimPrefix(s[end+1+len(frontMatterDelim):], "\n")
+
+	return nil
+}
+
+// postIDFromFilename returns the ID of the post stored in a file.
+func postIDFromFilename(name string) string {
+	return strings.TrimSuffix(name, MarkdownExt)
+}
+
+// findPostFile returns the path of the file storing a post in dir, in
+// whichever format it was written.
+func findPostFile(dir, id string) (string, error) {
+	if !plainIDPattern.MatchString(id) {
+		return "", fmt.Errorf("post %q: %w", id, fs.ErrNotExist)
+	}
+	for _, name := range []string{id + MarkdownExt, id} {
+		filepath := path.Join(dir, name)
+		if _, err := os.Stat(filepath); err == nil {
+			return filepath, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("post %s: %w", id, fs.ErrNotExist)
+}
+
+// decodePost decodes the contents of a post file, using its name to tell
+// the format.
+func decodePost(name string, b []byte) (*Post, error) {
+	post := new(Post)
+	if strings.HasSuffix(name, MarkdownExt) {
+		if err := unmarshalMarkdown(b, post); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(b, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func readPostFile(filepath string) (*Post, error) {
+	b, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return decodePost(filepath, b)
+}
+
+// writePost writes a post in the format of the service. If the post was
+// stored in another format, the old file is removed.
+func (svc postsService) writePost(p *Post) error {
+	var (
+		b        []byte
+		err      error
+		name     = p.ID
+		previous = p.ID + MarkdownExt
+	)
+
+	if svc.format == FormatMarkdown {
+		name, previous = previous, name
+		b, err = marshalMarkdown(p)
+	} else {
+		b, err = json.Marshal(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path.Join(svc.root, name), b, DefaultFileMode); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path.Join(svc.root, previous)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// ConvertToMarkdown rewrites all JSON post files as Markdown files with
+// front matter. Returns the number of converted posts.
+func (svc postsService) ConvertToMarkdown() (int, error) {
+	ids, err := svc.getAllPostIDs()
+	if err != nil {
+		return 0, fmt.Errorf("ConvertToMarkdown: %w", err)
+	}
+
+	md := svc
+	md.format = FormatMarkdown
+
+	var n int
+	for _, id := range ids {
+		filepath, err := findPostFile(svc.root, id)
+		if err != nil {
+			return n, fmt.Errorf("ConvertToMarkdown: %w", err)
+		}
+		if strings.HasSuffix(filepath, MarkdownExt) {
+			continue
+		}
+
+		p, err := readPostFile(filepath)
+		if err != nil {
+			return n, fmt.Errorf("ConvertToMarkdown: %s: %w", id, err)
+		}
+		if err := md.writePost(p); err != nil {
+			return n, fmt.Errorf("ConvertToMarkdown: %w", err)
+		}
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestMarkdownEncoding(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC)
+	post := &Post{
+		ID:           "abc",
+		Title:        "Hello: world",
+		Content:      "# Heading\n\n---\n\nSome text\n",
+		Tags:         []Tag{"a", "_dir:/foo/bar"},
+		CreatedTime:  now,
+		ModifiedTime: now.Add(time.Hour),
+	}
+
+	b, err := marshalMarkdown(post)
+	is.NoErr(err)
+	is.True(strings.HasPrefix(string(b), "---\nid: abc\ntitle: 'Hello: world'\n"))
+	is.True(strings.HasSuffix(string(b), "---\n\n"+post.Content))
+
+	decoded := new(Post)
+	is.NoErr(unmarshalMarkdown(b, decoded))
+	is.Equal(decoded, post)
+
+	t.Run("front matter is required", func(t *testing.T) {
+		is := is.New(t)
+		is.True(unmarshalMarkdown([]byte("# Heading"), new(Post)) != nil)
+		is.True(unmarshalMarkdown([]byte("---\nid: abc\n"), new(Post)) != nil)
+	})
+}
+
+func TestMarkdownPosts(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	jsonSvc := NewPostsService(tmpdir)
+	mdSvc := NewPostsServiceWithFormat(tmpdir, FormatMarkdown)
+
+	old := &Post{Title: "old", Content: "json"}
+	is.NoErr(jsonSvc.CreatePost(old))
+
+	post := &Post{Title: "new", Content: "markdown", Tags: []Tag{"a"}}
+	is.NoErr(mdSvc.CreatePost(post))
+
+	t.Run("new posts are written as markdown", func(t *testing.T) {
+		is := is.New(t)
+		_, err := os.Stat(path.Join(tmpdir, post.ID+MarkdownExt))
+		is.NoErr(err)
+	})
+
+	t.Run("posts in both formats are read", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := mdSvc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+
+		p, err := jsonSvc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "markdown")
+		is.Equal(p.Tags, []Tag{"a"})
+	})
+
+	t.Run("updating a json post converts it", func(t *testing.T) {
+		is := is.New(t)
+		old.Content = "converted"
+		is.NoErr(mdSvc.UpdatePost(old))
+
+		_, err := os.Stat(path.Join(tmpdir, old.ID))
+		is.True(os.IsNotExist(err))
+
+		p, err := mdSvc.GetPost(old.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "converted")
+	})
+
+	t.Run("markdown posts can be trashed and restored", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(mdSvc.DeletePost(post.ID))
+
+		deleted, err := mdSvc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(deleted), 1)
+		is.Equal(deleted[0].ID, post.ID)
+
+		is.NoErr(mdSvc.RestorePost(post.ID))
+		_, err = os.Stat(path.Join(tmpdir, post.ID+MarkdownExt))
+		is.NoErr(err)
+	})
+
+	t.Run("convert json posts to markdown", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(jsonSvc.UpdatePost(old))
+		is.NoErr(jsonSvc.CreatePost(&Post{Title: "another"}))
+
+		n, err := postsService{root: tmpdir}.ConvertToMarkdown()
+		is.NoErr(err)
+		is.Equal(n, 2)
+
+		entries, err := os.ReadDir(tmpdir)
+		is.NoErr(err)
+		for _, e := range entries {
+			is.True(e.IsDir() || strings.HasSuffix(e.Name(), MarkdownExt))
+		}
+
+		posts, err := jsonSvc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 3)
+	})
+}
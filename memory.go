@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// memoryPostsService keeps posts in memory only, so they are lost when the
+// program exits. It is meant for tests that need a PostsService, but not the
+// files behind it.
+type memoryPostsService struct {
+	// Serializes changes to posts, and guards everything but the index
+	mu sync.Mutex
+	// All posts that are not in the trash
+	index     *postIndex
+	trash     map[string]*DeletedPost
+	revisions map[string][]*Revision
+	folders   map[string]*FolderSettings
+}
+
+func NewMemoryPostsService() PostsService {
+	return &memoryPostsService{
+		index: &postIndex{
+			loaded: true,
+			posts:  make(map[string]*Post),
+			search: newSearchIndex(),
+			links:  newLinkIndex(),
+		},
+		trash:     make(map[string]*DeletedPost),
+		revisions: make(map[string][]*Revision),
+		folders:   make(map[string]*FolderSettings),
+	}
+}
+
+// cloneRevision returns a copy of a revision that can be modified without
+// affecting the original.
+func cloneRevision(rev *Revision) *Revision {
+	c := *rev
+	c.Tags = append([]Tag(nil), rev.Tags...)
+	return &c
+}
+
+// cloneFolderSettings returns a copy of folder settings that can be modified
+// without affecting the original.
+func cloneFolderSettings(s *FolderSettings) *FolderSettings {
+	c := *s
+	c.Order = append([]string(nil), s.Order...)
+	return &c
+}
+
+// Returns a single post by ID.
+func (svc *memoryPostsService) GetPost(id string) (*Post, error) {
+	p, ok := svc.index.get(id)
+	if !ok {
+		return nil, fmt.Errorf("GetPost: post %s: %w", id, fs.ErrNotExist)
+	}
+	return p, nil
+}
+
+// Returns a list of all posts matching the options.
+func (svc *memoryPostsService) ListPosts(opts *ListPostOptions) ([]*Post, error) {
+	if opts == nil {
+		opts = &ListPostOptions{}
+	}
+
+	// Search results are ordered by relevance
+	var posts []*Post
+	for _, p := range svc.index.find(opts.SearchTerm) {
+		if opts.matches(p) {
+			posts = append(posts, p)
+		}
+	}
+
+	return opts.sortAndPage(posts), nil
+}
+
+// Create a post. ID, CreatedTime and ModifiedTime will be overwritten if present.
+func (svc *memoryPostsService) CreatePost(p *Post) error {
+	now := time.Now()
+	id, err := ksuid.NewRandomWithTime(now)
+	if err != nil {
+		return fmt.Errorf("CreatePost: %w", err)
+	}
+
+	p.ID = id.String()
+	p.cleanTags()
+	p.CreatedTime = now
+	p.ModifiedTime = now
+
+	svc.index.set(p)
+	return nil
+}
+
+// Updates a posts title, content and tags. The version being replaced is kept
+// as a revision.
+func (svc *memoryPostsService) UpdatePost(p *Post) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	prev, ok := svc.index.get(p.ID)
+	if !ok {
+		return fmt.Errorf("UpdatePost: post %s: %w", p.ID, fs.ErrNotExist)
+	}
+	if p.BaseVersion != "" && p.BaseVersion != prev.Version() {
+		return fmt.Errorf("UpdatePost: %w", ErrConflict)
+	}
+
+	id, err := ksuid.NewRandomWithTime(prev.ModifiedTime)
+	if err != nil {
+		return fmt.Errorf("UpdatePost: %w", err)
+	}
+	rev := prev.Revision()
+	rev.ID = id.String()
+	svc.revisions[p.ID] = append(svc.revisions[p.ID], rev)
+
+	p.cleanTags()
+	p.ModifiedTime = time.Now()
+	p.BaseVersion = ""
+
+	svc.index.set(p)
+	return nil
+}
+
+// Returns all distinct tags of all posts, with their usage.
+func (svc *memoryPostsService) ListTags(opts *ListTagOptions) ([]*TagInfo, error) {
+	if opts == nil {
+		opts = new(ListTagOptions)
+	}
+	return tagInfos(svc.index.all(), opts), nil
+}
+
+func (svc *memoryPostsService) GetPostsFolderTree() (*Node, error) {
+	posts := svc.index.all()
+
+	svc.mu.Lock()
+	settings := make(map[string]*FolderSettings, len(svc.folders))
+	for dir, s := range svc.folders {
+		settings[dir] = cloneFolderSettings(s)
+	}
+	svc.mu.Unlock()
+
+	return buildFolderTree(posts, settings), nil
+}
+
+// Moves a post to the trash.
+func (svc *memoryPostsService) DeletePost(id string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	p, ok := svc.index.get(id)
+	if !ok {
+		return fmt.Errorf("DeletePost: post %s: %w", id, fs.ErrNotExist)
+	}
+	svc.index.remove(id)
+	svc.trash[id] = &DeletedPost{Post: *p, DeletedTime: time.Now()}
+
+	return nil
+}
+
+// Returns a single post from the trash.
+func (svc *memoryPostsService) GetDeletedPost(id string) (*DeletedPost, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	p, ok := svc.trash[id]
+	if !ok {
+		return nil, fmt.Errorf("GetDeletedPost: post %s: %w", id, fs.ErrNotExist)
+	}
+	return &DeletedPost{Post: *clonePost(&p.Post), DeletedTime: p.DeletedTime}, nil
+}
+
+// Returns all posts in the trash, most recently deleted first.
+func (svc *memoryPostsService) ListDeletedPosts() ([]*DeletedPost, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var posts []*DeletedPost
+	for _, p := range svc.trash {
+		posts = append(posts, &DeletedPost{Post: *clonePost(&p.Post), DeletedTime: p.DeletedTime})
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].DeletedTime.After(posts[j].DeletedTime)
+	})
+
+	return posts, nil
+}
+
+// Moves a post from the trash back to the list of posts.
+func (svc *memoryPostsService) RestorePost(id string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	p, ok := svc.trash[id]
+	if !ok {
+		return fmt.Errorf("RestorePost: post %s: %w", id, fs.ErrNotExist)
+	}
+	delete(svc.trash, id)
+	svc.index.set(&p.Post)
+
+	return nil
+}
+
+// Permanently removes a post from the trash, along with its revisions.
+func (svc *memoryPostsService) PurgePost(id string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if _, ok := svc.trash[id]; !ok {
+		return fmt.Errorf("PurgePost: post %s: %w", id, fs.ErrNotExist)
+	}
+	delete(svc.trash, id)
+	delete(svc.revisions, id)
+
+	return nil
+}
+
+// Permanently removes all posts that were deleted before the given time.
+// Returns the IDs of the purged posts.
+func (svc *memoryPostsService) PurgeDeletedPosts(before time.Time) ([]string, error) {
+	posts, err := svc.ListDeletedPosts()
+	if err != nil {
+		return nil, fmt.Errorf("PurgeDeletedPosts: %w", err)
+	}
+
+	var ids []string
+	for _, p := range posts {
+		if !p.DeletedTime.Before(before) {
+			continue
+		}
+		if err := svc.PurgePost(p.ID); err != nil {
+			return ids, fmt.Errorf("PurgeDeletedPosts: %w", err)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	return ids, nil
+}
+
+// Returns all previous versions of a post, newest first. The current version
+// of the post is not included.
+func (svc *memoryPostsService) ListRevisions(postID string) ([]*Revision, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var revs []*Revision
+	for _, rev := range svc.revisions[postID] {
+		revs = append(revs, cloneRevision(rev))
+	}
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].ModifiedTime.After(revs[j].ModifiedTime)
+	})
+
+	return revs, nil
+}
+
+// Returns a single previous version of a post.
+func (svc *memoryPostsService) GetRevision(postID, revisionID string) (*Revision, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, rev := range svc.revisions[postID] {
+		if rev.ID == revisionID {
+			return cloneRevision(rev), nil
+		}
+	}
+	return nil, fmt.Errorf("GetRevision: revision %s: %w", revisionID, fs.ErrNotExist)
+}
+
+// ResolveLink returns the post a wiki link target refers to, by ID or title.
+func (svc *memoryPostsService) ResolveLink(target string) (*Post, error) {
+	p, ok := svc.index.resolveLink(target)
+	if !ok {
+		return nil, fmt.Errorf("ResolveLink: %q: %w", target, fs.ErrNotExist)
+	}
+	return p, nil
+}
+
+// ListBacklinks returns all posts linking to a post.
+func (svc *memoryPostsService) ListBacklinks(postID string) ([]*Post, error) {
+	return svc.index.backlinks(postID), nil
+}
+
+// GetFolderSettings returns the settings of a folder. Folders without any
+// settings get the defaults.
+func (svc *memoryPostsService) GetFolderSettings(dir string) (*FolderSettings, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if s, ok := svc.folders[cleanDir(dir)]; ok {
+		return cloneFolderSettings(s), nil
+	}
+	return new(FolderSettings), nil
+}
+
+// UpdateFolderSettings replaces the settings of a folder. Default settings
+// are not stored.
+func (svc *memoryPostsService) UpdateFolderSettings(dir string, s *FolderSettings) error {
+	err := svc.changeFolderSettings(dir, func(*FolderSettings) (*FolderSettings, error) {
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateFolderSettings: %w", err)
+	}
+	return nil
+}
+
+// ChangeFolderSettings replaces the settings of a folder with those returned
+// by change for the current settings. The service is locked meanwhile, so
+// change must not use it.
+func (svc *memoryPostsService) ChangeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	if err := svc.changeFolderSettings(dir, change); err != nil {
+		return fmt.Errorf("ChangeFolderSettings: %w", err)
+	}
+	return nil
+}
+
+func (svc *memoryPostsService) changeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	dir = cleanDir(dir)
+	current, ok := svc.folders[dir]
+	if ok {
+		current = cloneFolderSettings(current)
+	} else {
+		current = new(FolderSettings)
+	}
+	s, err := change(current)
+	if err != nil {
+		return err
+	}
+
+	if s.IsZero() {
+		delete(svc.folders, dir)
+		return nil
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	svc.folders[dir] = cloneFolderSettings(s)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// postImporter is a posts service that can store posts as they are, keeping
+// their IDs, times and revisions.
+type postImporter interface {
+	PostsService
+	// importPost stores a post with its previous versions, replacing any
+	// existing post with the same ID. A non-zero deleted time puts it in the
+	// trash.
+	importPost(p *Post, revs []*Revision, deleted time.Time) error
+}
+
+// MigratePosts copies all posts from one posts service to another, along
+// with their revisions, the trash and the settings of folders with posts.
+// Posts that already exist in the target are replaced, so an interrupted
+// migration can be run again. Returns the number of copied posts.
+func MigratePosts(from PostsService, to postImporter) (int, error) {
+	posts, err := from.ListPosts(nil)
+	if err != nil {
+		return 0, fmt.Errorf("MigratePosts: %w", err)
+	}
+	trashed, err := from.ListDeletedPosts()
+	if err != nil {
+		return 0, fmt.Errorf("MigratePosts: %w", err)
+	}
+
+	var n int
+	migrate := func(p *Post, deleted time.Time) error {
+		revs, err := from.ListRevisions(p.ID)
+		if err != nil {
+			return err
+		}
+		if err := to.importPost(p, revs, deleted); err != nil {
+			return fmt.Errorf("post %s: %w", p.ID, err)
+		}
+		n++
+		return nil
+	}
+
+	for _, p := range posts {
+		if err := migrate(p, time.Time{}); err != nil {
+			return n, fmt.Errorf("MigratePosts: %w", err)
+		}
+	}
+	for _, p := range trashed {
+		if err := migrate(&p.Post, p.DeletedTime); err != nil {
+			return n, fmt.Errorf("MigratePosts: %w", err)
+		}
+	}
+
+	tree, err := from.GetPostsFolderTree()
+	if err != nil {
+		return n, fmt.Errorf("MigratePosts: %w", err)
+	}
+	if err := migrateFolderSettings(tree, to); err != nil {
+		return n, fmt.Errorf("MigratePosts: %w", err)
+	}
+
+	return n, nil
+}
+
+func migrateFolderSettings(node *Node, to PostsService) error {
+	if !node.Settings.IsZero() {
+		if err := to.UpdateFolderSettings(node.Path, node.Settings); err != nil {
+			return err
+		}
+	}
+	for _, child := range node.Children {
+		if err := migrateFolderSettings(child, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc postsService) importPost(p *Post, revs []*Revision, deleted time.Time) error {
+	defer svc.locks.lock(p.ID)()
+
+	// A previous version in the trash would shadow a restored post
+	for _, name := range []string{p.ID, p.ID + MarkdownExt} {
+		if err := os.Remove(path.Join(svc.trashDir(), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := svc.writePost(p); err != nil {
+		return err
+	}
+	svc.index.set(p)
+
+	if !deleted.IsZero() {
+		filepath, err := findPostFile(svc.root, p.ID)
+		if err != nil {
+			return err
+		}
+		if err := svc.moveToTrash(filepath, deleted); err != nil {
+			return err
+		}
+		svc.index.remove(p.ID)
+	}
+
+	for _, rev := range revs {
+		if err := svc.writeRevision(rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestMigratePosts(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	files := NewPostsService(dir).(*postsService)
+	a := &Post{Title: "a", Content: "one", Tags: []Tag{"x", "_dir:/work"}}
+	is.NoErr(files.CreatePost(a))
+	a.Content = "two"
+	is.NoErr(files.UpdatePost(a))
+	b := &Post{Title: "b"}
+	is.NoErr(files.CreatePost(b))
+	is.NoErr(files.DeletePost(b.ID))
+	is.NoErr(files.UpdateFolderSettings("work", &FolderSettings{Description: "Work"}))
+
+	// check compares the posts of a service with the original ones
+	check := func(t *testing.T, svc PostsService) {
+		is := is.New(t)
+		p, err := svc.GetPost(a.ID)
+		is.NoErr(err)
+		is.Equal(p.Version(), a.Version())
+		is.True(p.CreatedTime.Equal(a.CreatedTime))
+
+		revs, err := svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 1)
+		is.Equal(revs[0].Content, "one")
+
+		trashed, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(trashed), 1)
+		is.Equal(trashed[0].ID, b.ID)
+
+		s, err := svc.GetFolderSettings("work")
+		is.NoErr(err)
+		is.Equal(s.Description, "Work")
+	}
+
+	db := newTestSQLitePostsService(t)
+
+	t.Run("from files to sqlite", func(t *testing.T) {
+		is := is.New(t)
+		n, err := MigratePosts(files, db)
+		is.NoErr(err)
+		is.Equal(n, 2)
+		check(t, db)
+
+		// Migrating again replaces the posts
+		n, err = MigratePosts(files, db)
+		is.NoErr(err)
+		is.Equal(n, 2)
+		check(t, db)
+	})
+
+	t.Run("from sqlite to files", func(t *testing.T) {
+		is := is.New(t)
+		dir, err := os.MkdirTemp("", "")
+		is.NoErr(err)
+		defer os.RemoveAll(dir)
+
+		files := NewPostsService(dir).(*postsService)
+		n, err := MigratePosts(db, files)
+		is.NoErr(err)
+		is.Equal(n, 2)
+		check(t, files)
+	})
+}
@@ -8,9 +8,23 @@ import (
 const TagPathSeparator = "/"
 
 type Node struct {
-	Label    string
+	Label string
+	// Labels of the node and its ancestors, separated by TagPathSeparator.
+	// Empty for the root.
+	Path     string
 	Value    []*Post
 	Children []*Node
+	// Settings of a folder. Nil if the node is not a folder, or the folder
+	// has default settings.
+	Settings *FolderSettings
+}
+
+// Icon returns the Bootstrap icon of a folder.
+func (node *Node) Icon() string {
+	if node.Settings != nil && node.Settings.Icon != "" {
+		return node.Settings.Icon
+	}
+	return "bi-folder"
 }
 
 // NewOrExisting returns an existing node, or creates a new, at the specified
@@ -33,11 +47,28 @@ func (node *Node) NewOrExisting(k string) *Node {
 
 	child := &Node{
 		Label: current,
+		Path:  strings.TrimPrefix(node.Path+TagPathSeparator+current, TagPathSeparator),
 	}
 	node.Children = append(node.Children, child)
 	return child.NewOrExisting(strings.Join(segs, TagPathSeparator))
 }
 
+// Find returns the node at the specified path, or nil if there is none. An
+// empty path returns the current node.
+func (node *Node) Find(k string) *Node {
+	if k = strings.Trim(k, TagPathSeparator); k == "" {
+		return node
+	}
+
+	current, rest, _ := strings.Cut(k, TagPathSeparator)
+	for _, child := range node.Children {
+		if child.Label == current {
+			return child.Find(rest)
+		}
+	}
+	return nil
+}
+
 // BuildTree builds a node tree with values from a map.
 // The root of the tree has no label by default.
 func BuildTree(m map[string][]*Post) *Node {
@@ -57,3 +88,38 @@ func BuildTree(m map[string][]*Post) *Node {
 
 	return tree
 }
+
+// Filter returns a copy of the node and its descendants with only the posts
+// keep returns true for. Descendants left without posts are dropped.
+func (node *Node) Filter(keep func(*Post) bool) *Node {
+	filtered := *node
+	filtered.Value, filtered.Children = nil, nil
+	for _, p := range node.Value {
+		if keep(p) {
+			filtered.Value = append(filtered.Value, p)
+		}
+	}
+	for _, child := range node.Children {
+		if c := child.Filter(keep); len(c.Value) > 0 || len(c.Children) > 0 {
+			filtered.Children = append(filtered.Children, c)
+		}
+	}
+	return &filtered
+}
+
+// PostCount returns the number of distinct posts in the node and all its
+// descendants.
+func (node *Node) PostCount() int {
+	seen := make(map[string]bool)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, p := range n.Value {
+			seen[p.ID] = true
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(node)
+	return len(seen)
+}
@@ -62,4 +62,55 @@ func TestBuildTree(t *testing.T) {
 	is.Equal(len(foo.Children), 1)
 	foobar := foo.Children[0]
 	is.Equal(foobar.Label, "bar")
+	is.Equal(foobar.Path, "foo/bar")
+}
+
+func TestPostCount(t *testing.T) {
+	is := is.New(t)
+
+	a, b := &Post{ID: "a"}, &Post{ID: "b"}
+	tree := BuildTree(map[string][]*Post{
+		"foo":     {a},
+		"foo/bar": {a, b},
+		"baz":     {b},
+	})
+
+	is.Equal(tree.PostCount(), 2)
+	foo := tree.Children[1]
+	is.Equal(foo.Label, "foo")
+	is.Equal(foo.PostCount(), 2)
+	is.Equal(foo.Children[0].PostCount(), 2)
+}
+
+func TestFind(t *testing.T) {
+	is := is.New(t)
+
+	tree := BuildTree(map[string][]*Post{"foo/bar": nil})
+
+	is.Equal(tree.Find(""), tree)
+	is.Equal(tree.Find("/foo/bar/").Path, "foo/bar")
+	is.Equal(tree.Find("foo/baz"), nil)
+	is.Equal(tree.Find("bar"), nil)
+}
+
+func TestFilter(t *testing.T) {
+	is := is.New(t)
+
+	a, b := &Post{ID: "a"}, &Post{ID: "b"}
+	tree := BuildTree(map[string][]*Post{
+		"foo":     {a, b},
+		"foo/bar": {b},
+		"baz":     {b},
+	})
+
+	filtered := tree.Filter(func(p *Post) bool { return p.ID == "a" })
+	is.Equal(len(filtered.Children), 1)
+	foo := filtered.Children[0]
+	is.Equal(foo.Label, "foo")
+	is.Equal(foo.Value, []*Post{a})
+	is.Equal(len(foo.Children), 0)
+
+	// The tree itself is left alone
+	is.Equal(len(tree.Children), 2)
+	is.Equal(len(tree.Find("foo").Value), 2)
 }
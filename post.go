@@ -1,14 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"os"
-	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gomarkdown/markdown"
@@ -22,18 +22,50 @@ type PostsService interface {
 	ListPosts(opts *ListPostOptions) ([]*Post, error)
 	UpdatePost(post *Post) error
 	CreatePost(post *Post) error
-	ListTags(opts *ListTagOptions) ([]Tag, error)
+	ListTags(opts *ListTagOptions) ([]*TagInfo, error)
 	GetPostsFolderTree() (*Node, error)
+	DeletePost(id string) error
+	GetDeletedPost(id string) (*DeletedPost, error)
+	ListDeletedPosts() ([]*DeletedPost, error)
+	RestorePost(id string) error
+	PurgePost(id string) error
+	PurgeDeletedPosts(before time.Time) ([]string, error)
+	ListRevisions(postID string) ([]*Revision, error)
+	GetRevision(postID, revisionID string) (*Revision, error)
+	ResolveLink(target string) (*Post, error)
+	ListBacklinks(postID string) ([]*Post, error)
+	GetFolderSettings(dir string) (*FolderSettings, error)
+	UpdateFolderSettings(dir string, s *FolderSettings) error
+	ChangeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error
 }
 
 type postsService struct {
 	// Path to directory where posts are stored
 	root string
+	// Format of new and updated post files. Posts in all formats are read.
+	format PostFormat
+	// Keep previous versions of posts in the revisions directory on update
+	saveRevisions bool
+	// Cache of all posts, shared by all copies of the service
+	index *postIndex
+	// Serializes changes to the folder settings file
+	foldersMu *sync.Mutex
+	// Serializes changes to each post
+	locks *postLocks
 }
 
 func NewPostsService(root string) PostsService {
+	return NewPostsServiceWithFormat(root, FormatJSON)
+}
+
+func NewPostsServiceWithFormat(root string, format PostFormat) PostsService {
 	return &postsService{
-		root: root,
+		root:          root,
+		format:        format,
+		saveRevisions: true,
+		index:         newPostIndex(),
+		foldersMu:     new(sync.Mutex),
+		locks:         newPostLocks(),
 	}
 }
 
@@ -46,6 +78,54 @@ type Post struct {
 	Tags         []Tag
 	CreatedTime  time.Time
 	ModifiedTime time.Time
+	// Version of the post an update is based on, if known. Updates of a post
+	// that was changed since are rejected with ErrConflict. It is not stored.
+	BaseVersion string `json:"-"`
+}
+
+// ErrConflict is returned when updating a post that was changed since the
+// version the update is based on.
+var ErrConflict = errors.New("the post was changed in the meantime")
+
+// Version identifies the current version of the post. It changes with every
+// update.
+func (p *Post) Version() string {
+	return p.Revision().Version()
+}
+
+// Number of times a change of a post is tried when the post keeps being
+// changed by someone else at the same time.
+const maxChangeAttempts = 3
+
+// changePost applies a change to a post that was read before and saves it,
+// unless the post was changed since it was read. Then the change is applied
+// again to the newer version, up to maxChangeAttempts times before giving up
+// with ErrConflict. The change returns false to leave the post as it is. The
+// post is returned as it was changed, or as it is if it was left as it is.
+func changePost(svc PostsService, post *Post, change func(*Post) (bool, error)) (*Post, error) {
+	for attempt := 1; ; attempt++ {
+		version := post.Version()
+		ok, err := change(post)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return post, nil
+		}
+
+		post.BaseVersion = version
+		err = svc.UpdatePost(post)
+		if err == nil {
+			return post, nil
+		}
+		if !errors.Is(err, ErrConflict) || attempt == maxChangeAttempts {
+			return nil, err
+		}
+
+		if post, err = svc.GetPost(post.ID); err != nil {
+			return nil, err
+		}
+	}
 }
 
 var mdRenderer = html.NewRenderer(
@@ -54,7 +134,12 @@ var mdRenderer = html.NewRenderer(
 var htmlSanitizer = bluemonday.UGCPolicy()
 
 func (p *Post) ContentHTML() template.HTML {
-	s := string(markdown.ToHTML([]byte(p.Content), nil, mdRenderer))
+	return renderMarkdown(p.Content)
+}
+
+// renderMarkdown renders Markdown as sanitized HTML.
+func renderMarkdown(md string) template.HTML {
+	s := string(markdown.ToHTML([]byte(md), nil, mdRenderer))
 	s = htmlSanitizer.Sanitize(s)
 	return template.HTML(s)
 }
@@ -85,22 +170,125 @@ func (p *Post) cleanTags() {
 	}
 }
 
+// IsWithin reports whether the tag is another tag, or one of its
+// descendants in a tag hierarchy, like `lang/go/testing` is within `lang/go`.
+func (t Tag) IsWithin(parent string) bool {
+	parent = strings.TrimSuffix(parent, TagPathSeparator)
+	return string(t) == parent || strings.HasPrefix(string(t), parent+TagPathSeparator)
+}
+
+// hasAnyTag reports whether the post has at least one of the tags, or any of
+// their descendants.
+func (p *Post) hasAnyTag(tags []string) bool {
+	for _, t := range tags {
+		for _, pt := range p.Tags {
+			if pt.IsWithin(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Functional tags changing how posts are listed
+const (
+	// Pinned posts are listed first
+	PinnedTag Tag = "_pinned"
+	// Archived posts are left out of listings and search, unless asked for
+	ArchivedTag Tag = "_archived"
+	// Drafts are marked as such, and left out of API listings, unless asked
+	// for
+	DraftTag Tag = "_draft"
+)
+
+// hasTag reports whether the post has exactly the tag.
+func (p *Post) hasTag(tag Tag) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Post) IsPinned() bool {
+	return p.hasTag(PinnedTag)
+}
+
+func (p *Post) IsArchived() bool {
+	return p.hasTag(ArchivedTag)
+}
+
+func (p *Post) IsDraft() bool {
+	return p.hasTag(DraftTag)
+}
+
+// pinnedFirst moves pinned posts to the front, keeping the order of the
+// posts otherwise.
+func pinnedFirst(posts []*Post) {
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].IsPinned() && !posts[j].IsPinned()
+	})
+}
+
+// Prefix of functional tags placing a post in a folder, e.g. `_dir:/foo/bar`.
+const DirTagPrefix = "_dir:"
+
+// Dirs returns the folders the post is placed in.
+func (p *Post) Dirs() []string {
+	var dirs []string
+	for _, t := range p.Tags {
+		if dir, ok := strings.CutPrefix(string(t), DirTagPrefix); ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// inDir reports whether the post is placed in a folder or any of its
+// sub-folders.
+func (p *Post) inDir(dir string) bool {
+	dir = strings.Trim(dir, TagPathSeparator)
+	for _, d := range p.Dirs() {
+		d = strings.Trim(d, TagPathSeparator)
+		if dir == "" || d == dir || strings.HasPrefix(d, dir+TagPathSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
 // Returns a single post by ID.
 func (svc postsService) GetPost(id string) (*Post, error) {
-	filepath := path.Join(svc.root, id)
-	b, err := os.ReadFile(filepath)
-	if err != nil {
+	if err := svc.index.load(svc); err != nil {
 		return nil, fmt.Errorf("GetPost: %w", err)
 	}
+	if p, ok := svc.index.get(id); ok {
+		return p, nil
+	}
 
-	post := new(Post)
-	if err := json.Unmarshal(b, post); err != nil {
+	// The post might have been added by another program
+	post, err := svc.readPost(id)
+	if err != nil {
 		return nil, fmt.Errorf("GetPost: %w", err)
 	}
+	if post.ID != id {
+		return nil, fmt.Errorf("GetPost: post %s: file has ID %q: %w", id, post.ID, fs.ErrNotExist)
+	}
+	svc.index.set(post)
 
 	return post, nil
 }
 
+// readPost reads a post from disk, bypassing the index.
+func (svc postsService) readPost(id string) (*Post, error) {
+	filepath, err := findPostFile(svc.root, id)
+	if err != nil {
+		return nil, err
+	}
+	return readPostFile(filepath)
+}
+
 func (svc postsService) getAllPostIDs() ([]string, error) {
 	var ids []string
 
@@ -114,7 +302,16 @@ func (svc postsService) getAllPostIDs() ([]string, error) {
 			return nil
 		}
 
-		id := strings.TrimSuffix(strings.TrimPrefix(path, "./"), ".json")
+		// Posts are only stored at the top level. Sub-directories, like the
+		// trash, are managed separately.
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		id := postIDFromFilename(strings.TrimPrefix(path, "./"))
 		ids = append(ids, id)
 
 		return nil
@@ -127,8 +324,176 @@ func (svc postsService) getAllPostIDs() ([]string, error) {
 }
 
 type ListPostOptions struct {
+	// Full-text search query. Words are matched regardless of their form,
+	// and text in double quotes is matched as a phrase. Posts are ordered
+	// by relevance when searching.
 	SearchTerm string
 	TagsFilter []string
+	// How posts are matched against TagsFilter. Defaults to TagMatchAny.
+	TagMatch TagMatchMode
+	// Posts with any of these tags are left out
+	ExcludeTags []string
+	// Case-insensitive substrings that must all be part of the title
+	TitleTerms []string
+	// Only posts in this folder, or any of its sub-folders
+	Dir string
+	// Leave out posts tagged ArchivedTag
+	ExcludeArchived bool
+	// Leave out posts tagged DraftTag
+	ExcludeDrafts bool
+	// Time ranges for creation and modification. Zero values are ignored.
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// Order of the posts. Defaults to SortByRelevance when searching, and
+	// SortByCreated otherwise. Pinned posts always come first.
+	SortBy PostSortKey
+	// Sort in descending order. Ignored when sorting by relevance, which is
+	// always best match first.
+	SortDescending bool
+	// Number of posts to skip
+	Offset int
+	// Maximum number of posts to return. No limit if zero.
+	Limit int
+}
+
+type PostSortKey string
+
+const (
+	SortByCreated   PostSortKey = "created"
+	SortByModified  PostSortKey = "modified"
+	SortByTitle     PostSortKey = "title"
+	SortByRelevance PostSortKey = "relevance"
+)
+
+// ParsePostSortKey returns the sort key with the given name. An empty name
+// returns an empty key, which selects the default order.
+func ParsePostSortKey(s string) (PostSortKey, error) {
+	switch k := PostSortKey(s); k {
+	case "", SortByCreated, SortByModified, SortByTitle, SortByRelevance:
+		return k, nil
+	}
+	return "", fmt.Errorf("invalid sort key %q", s)
+}
+
+// sortAndPage orders posts, which are expected to be in relevance order when
+// searching, and returns the requested page of them.
+func (opts *ListPostOptions) sortAndPage(posts []*Post) []*Post {
+	key := opts.SortBy
+	if key == "" || key == SortByRelevance {
+		// There's no relevance without a search
+		key = SortByRelevance
+		if opts.SearchTerm == "" {
+			key = SortByCreated
+		}
+	}
+
+	if key != SortByRelevance {
+		less := map[PostSortKey]func(a, b *Post) bool{
+			SortByCreated: func(a, b *Post) bool {
+				return a.CreatedTime.Before(b.CreatedTime)
+			},
+			SortByModified: func(a, b *Post) bool {
+				return a.ModifiedTime.Before(b.ModifiedTime)
+			},
+			SortByTitle: func(a, b *Post) bool {
+				return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+			},
+		}[key]
+		sort.SliceStable(posts, func(i, j int) bool {
+			if opts.SortDescending {
+				return less(posts[j], posts[i])
+			}
+			return less(posts[i], posts[j])
+		})
+	}
+	pinnedFirst(posts)
+
+	if opts.Offset > 0 {
+		if opts.Offset >= len(posts) {
+			return nil
+		}
+		posts = posts[opts.Offset:]
+	}
+	if opts.Limit > 0 && opts.Limit < len(posts) {
+		posts = posts[:opts.Limit]
+	}
+
+	return posts
+}
+
+type TagMatchMode string
+
+const (
+	// Posts with at least one of the tags
+	TagMatchAny TagMatchMode = "any"
+	// Posts with every one of the tags
+	TagMatchAll TagMatchMode = "all"
+	// Posts with none of the tags
+	TagMatchNone TagMatchMode = "none"
+)
+
+// ParseTagMatchMode returns the mode with the given name. An empty name
+// returns the default mode.
+func ParseTagMatchMode(s string) (TagMatchMode, error) {
+	switch m := TagMatchMode(s); m {
+	case "":
+		return TagMatchAny, nil
+	case TagMatchAny, TagMatchAll, TagMatchNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid tag match mode %q", s)
+}
+
+func (opts *ListPostOptions) matchesTags(p *Post) bool {
+	if len(opts.TagsFilter) == 0 {
+		return true
+	}
+
+	switch opts.TagMatch {
+	case TagMatchAll:
+		for _, t := range opts.TagsFilter {
+			if !p.hasAnyTag([]string{t}) {
+				return false
+			}
+		}
+		return true
+	case TagMatchNone:
+		return !p.hasAnyTag(opts.TagsFilter)
+	default:
+		return p.hasAnyTag(opts.TagsFilter)
+	}
+}
+
+// matches reports whether a post passes all filters except the search term.
+func (opts *ListPostOptions) matches(p *Post) bool {
+	if !opts.matchesTags(p) {
+		return false
+	}
+	if len(opts.ExcludeTags) > 0 && p.hasAnyTag(opts.ExcludeTags) {
+		return false
+	}
+
+	title := strings.ToLower(p.Title)
+	for _, s := range opts.TitleTerms {
+		if !strings.Contains(title, strings.ToLower(s)) {
+			return false
+		}
+	}
+
+	if opts.Dir != "" && !p.inDir(opts.Dir) {
+		return false
+	}
+	if (opts.ExcludeArchived && p.IsArchived()) || (opts.ExcludeDrafts && p.IsDraft()) {
+		return false
+	}
+
+	inRange := func(t, after, before time.Time) bool {
+		return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+	}
+	return inRange(p.CreatedTime, opts.CreatedAfter, opts.CreatedBefore) &&
+		inRange(p.ModifiedTime, opts.ModifiedAfter, opts.ModifiedBefore)
 }
 
 // Create a post. ID, CreatedTime and ModifiedTime will be overwritten if present.
@@ -144,97 +509,65 @@ func (svc postsService) CreatePost(p *Post) error {
 	p.CreatedTime = now
 	p.ModifiedTime = now
 
-	b, err := json.Marshal(p)
-	if err != nil {
-		return fmt.Errorf("CreatePost: %w", err)
-	}
-
-	filepath := path.Join(svc.root, id.String())
-	if err := os.WriteFile(filepath, b, DefaultFileMode); err != nil {
+	if err := svc.writePost(p); err != nil {
 		return fmt.Errorf("CreatePost: %w", err)
 	}
+	svc.index.set(p)
 
 	return nil
 }
 
-// Returns a list of all posts.
+// Returns a list of all posts matching the options.
 func (svc postsService) ListPosts(opts *ListPostOptions) ([]*Post, error) {
 	if opts == nil {
 		opts = &ListPostOptions{}
 	}
 
-	ids, err := svc.getAllPostIDs()
-	if err != nil {
+	if err := svc.index.load(svc); err != nil {
 		return nil, err
 	}
 
-	contentFilterFunc := func(p *Post) bool {
-		x := strings.ToLower(opts.SearchTerm)
-		a := strings.ToLower(p.Title)
-		b := strings.ToLower(p.Content)
-		return strings.Contains(a, x) || strings.Contains(b, x)
-	}
-
-	tagFilterFunc := func(p *Post) bool {
-		for _, t := range opts.TagsFilter {
-			for _, pt := range p.Tags {
-				if string(pt) == t {
-					return true
-				}
-			}
-		}
-		return false
-	}
+	// Search results are ordered by relevance
+	candidates := svc.index.find(opts.SearchTerm)
 
 	var posts []*Post
-	for _, id := range ids {
-		p, err := svc.GetPost(id)
-		if err != nil {
-			return nil, err
-		}
-
-		doContentFilter := len(opts.SearchTerm) > 0
-		doTagsFilter := len(opts.TagsFilter) > 0
-
-		if doContentFilter && doTagsFilter {
-			if contentFilterFunc(p) && tagFilterFunc(p) {
-				posts = append(posts, p)
-			}
-		} else if doContentFilter {
-			if contentFilterFunc(p) {
-				posts = append(posts, p)
-			}
-		} else if doTagsFilter {
-			if tagFilterFunc(p) {
-				posts = append(posts, p)
-			}
-		} else {
+	for _, p := range candidates {
+		if opts.matches(p) {
 			posts = append(posts, p)
 		}
 	}
 
-	return posts, nil
+	return opts.sortAndPage(posts), nil
 }
 
 // Updates a posts title and content. All other fields are ignored.
 func (svc postsService) UpdatePost(p *Post) error {
+	defer svc.locks.lock(p.ID)()
+
 	// Make sure it exists
-	if _, err := svc.GetPost(p.ID); err != nil {
+	prev, err := svc.GetPost(p.ID)
+	if err != nil {
 		return fmt.Errorf("UpdatePost: %w", err)
 	}
+	if p.BaseVersion != "" && p.BaseVersion != prev.Version() {
+		return fmt.Errorf("UpdatePost: %w", ErrConflict)
+	}
+
+	// Keep the version being overwritten
+	if svc.saveRevisions {
+		if err := svc.saveRevision(prev); err != nil {
+			return fmt.Errorf("UpdatePost: %w", err)
+		}
+	}
 
 	p.cleanTags()
 	p.ModifiedTime = time.Now()
+	p.BaseVersion = ""
 
-	b, err := json.Marshal(p)
-	if err != nil {
-		return fmt.Errorf("UpdatePost: %w", err)
-	}
-
-	filepath := path.Join(svc.root, p.ID)
-	if err := os.WriteFile(filepath, b, DefaultFileMode); err != nil {
+	if err := svc.writePost(p); err != nil {
 		return fmt.Errorf("UpdatePost: %w", err)
 	}
+	svc.index.set(p)
 
 	return nil
 }
@@ -242,10 +575,42 @@ func (svc postsService) UpdatePost(p *Post) error {
 type ListTagOptions struct {
 	// Ignore tags with a functional meaning.
 	IgnoreFunctional bool
+	// Order of the tags. Defaults to TagSortByName. Counts and times are
+	// sorted in descending order.
+	SortBy TagSortKey
+}
+
+// TagInfo describes the usage of a tag.
+type TagInfo struct {
+	Name Tag
+	// Number of posts having the tag
+	Count int
+	// Most recent modification time of the posts having the tag
+	LastUsed time.Time
 }
 
-// Returns a list of all distinct tags of all posts.
-func (svc postsService) ListTags(opts *ListTagOptions) ([]Tag, error) {
+type TagSortKey string
+
+const (
+	TagSortByName     TagSortKey = "name"
+	TagSortByCount    TagSortKey = "count"
+	TagSortByLastUsed TagSortKey = "used"
+)
+
+// ParseTagSortKey returns the tag sort key with the given name. An empty name
+// returns TagSortByName.
+func ParseTagSortKey(s string) (TagSortKey, error) {
+	switch k := TagSortKey(s); k {
+	case "":
+		return TagSortByName, nil
+	case TagSortByName, TagSortByCount, TagSortByLastUsed:
+		return k, nil
+	}
+	return "", fmt.Errorf("invalid tag sort key %q", s)
+}
+
+// Returns all distinct tags of all posts, with their usage.
+func (svc postsService) ListTags(opts *ListTagOptions) ([]*TagInfo, error) {
 	if opts == nil {
 		opts = new(ListTagOptions)
 	}
@@ -255,25 +620,45 @@ func (svc postsService) ListTags(opts *ListTagOptions) ([]Tag, error) {
 		return nil, fmt.Errorf("ListTags: %w", err)
 	}
 
-	uniqueTags := make(map[Tag]bool)
+	return tagInfos(posts, opts), nil
+}
+
+// tagInfos collects the tags of posts.
+func tagInfos(posts []*Post, opts *ListTagOptions) []*TagInfo {
+	infos := make(map[Tag]*TagInfo)
 	for _, p := range posts {
 		for _, tag := range p.Tags {
 			if opts.IgnoreFunctional && strings.HasPrefix(string(tag), "_") {
 				continue
 			}
-			uniqueTags[tag] = true
+			info, ok := infos[tag]
+			if !ok {
+				info = &TagInfo{Name: tag}
+				infos[tag] = info
+			}
+			info.Count++
+			if p.ModifiedTime.After(info.LastUsed) {
+				info.LastUsed = p.ModifiedTime
+			}
 		}
 	}
 
-	var tags []Tag
-	for tag := range uniqueTags {
-		tags = append(tags, tag)
+	tags := make([]*TagInfo, 0, len(infos))
+	for _, info := range infos {
+		tags = append(tags, info)
 	}
 	sort.Slice(tags, func(i, j int) bool {
-		return tags[i] < tags[j]
+		a, b := tags[i], tags[j]
+		switch {
+		case opts.SortBy == TagSortByCount && a.Count != b.Count:
+			return a.Count > b.Count
+		case opts.SortBy == TagSortByLastUsed && !a.LastUsed.Equal(b.LastUsed):
+			return a.LastUsed.After(b.LastUsed)
+		}
+		return a.Name < b.Name
 	})
 
-	return tags, nil
+	return tags
 }
 
 func (svc postsService) GetPostsFolderTree() (*Node, error) {
@@ -282,15 +667,45 @@ func (svc postsService) GetPostsFolderTree() (*Node, error) {
 		return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
 	}
 
+	settings, err := svc.readFolderSettings()
+	if err != nil {
+		return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+	}
+
+	return buildFolderTree(posts, settings), nil
+}
+
+// buildFolderTree builds the folder tree of posts, with the settings of each
+// folder by path.
+func buildFolderTree(posts []*Post, settings map[string]*FolderSettings) *Node {
 	folders := make(map[string][]*Post)
 	for _, p := range posts {
-		for _, t := range p.Tags {
-			parts := strings.SplitN(string(t), ":", 2)
-			if len(parts) == 2 && parts[0] == "_dir" {
-				folders[parts[1]] = append(folders[parts[1]], p)
+		for _, dir := range p.Dirs() {
+			folders[dir] = append(folders[dir], p)
+		}
+	}
+	tree := BuildTree(folders)
+	applyFolderSettings(tree, settings)
+
+	return tree
+}
+
+// BuildTagTree builds the hierarchy of the tags of posts. Each node holds the
+// posts having that exact tag.
+func BuildTagTree(posts []*Post, opts *ListTagOptions) *Node {
+	if opts == nil {
+		opts = new(ListTagOptions)
+	}
+
+	tags := make(map[string][]*Post)
+	for _, p := range posts {
+		for _, tag := range p.Tags {
+			if opts.IgnoreFunctional && strings.HasPrefix(string(tag), "_") {
+				continue
 			}
+			tags[string(tag)] = append(tags[string(tag)], p)
 		}
 	}
 
-	return BuildTree(folders), nil
+	return BuildTree(tags)
 }
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -105,11 +112,11 @@ func TestPosts(t *testing.T) {
 		})
 		is.NoErr(err)
 		is.Equal(len(tags), 5)
-		is.Equal(tags[0], Tag("a"))
-		is.Equal(tags[1], Tag("b"))
-		is.Equal(tags[2], Tag("c"))
-		is.Equal(tags[3], Tag("d"))
-		is.Equal(tags[4], Tag("e"))
+		is.Equal(tags[0].Name, Tag("a"))
+		is.Equal(tags[1].Name, Tag("b"))
+		is.Equal(tags[2].Name, Tag("c"))
+		is.Equal(tags[3].Name, Tag("d"))
+		is.Equal(tags[4].Name, Tag("e"))
 	})
 
 	t.Run("list tree", func(t *testing.T) {
@@ -140,3 +147,147 @@ func TestPosts(t *testing.T) {
 		is.Equal(post.Title, "alice")
 	})
 }
+
+func TestGetPostIDsAreNotPaths(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	root := path.Join(tmpdir, "posts")
+	is.NoErr(os.Mkdir(root, 0750))
+	svc := NewPostsService(root)
+
+	// A post outside of the posts root
+	is.NoErr(os.WriteFile(path.Join(tmpdir, "secret"),
+		[]byte(`{"ID": "secret", "Title": "secret", "Content": "hunter2"}`), DefaultFileMode))
+	// A post with the ID of another one
+	p := &Post{Title: "a"}
+	is.NoErr(svc.CreatePost(p))
+	b, err := os.ReadFile(path.Join(root, p.ID))
+	is.NoErr(err)
+	is.NoErr(os.WriteFile(path.Join(root, "copy"), b, DefaultFileMode))
+
+	for _, id := range []string{"../secret", "../posts/" + p.ID, "copy"} {
+		_, err := svc.GetPost(id)
+		is.True(errors.Is(err, fs.ErrNotExist))
+	}
+
+	app := NewApp(svc, ":1337")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/posts/?template="+url.QueryEscape("../secret"), nil))
+	is.True(!strings.Contains(w.Body.String(), "hunter2"))
+}
+
+func TestListPostOptionsSortAndPage(t *testing.T) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	newPosts := func() []*Post {
+		// In relevance order
+		return []*Post{
+			{ID: "a", Title: "beta", CreatedTime: base.Add(2 * time.Hour), ModifiedTime: base.Add(3 * time.Hour)},
+			{ID: "b", Title: "Alpha", CreatedTime: base, ModifiedTime: base.Add(4 * time.Hour)},
+			{ID: "c", Title: "gamma", CreatedTime: base.Add(time.Hour), ModifiedTime: base.Add(time.Hour)},
+		}
+	}
+	ids := func(posts []*Post) (s string) {
+		for _, p := range posts {
+			s += p.ID
+		}
+		return s
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts ListPostOptions
+		want string
+	}{
+		{"created by default", ListPostOptions{}, "bca"},
+		{"relevance when searching", ListPostOptions{SearchTerm: "x"}, "abc"},
+		{"relevance without a search", ListPostOptions{SortBy: SortByRelevance}, "bca"},
+		{"created descending", ListPostOptions{SortBy: SortByCreated, SortDescending: true}, "acb"},
+		{"modified", ListPostOptions{SortBy: SortByModified}, "cab"},
+		{"title ignores case", ListPostOptions{SortBy: SortByTitle}, "bac"},
+		{"offset", ListPostOptions{Offset: 1}, "ca"},
+		{"limit", ListPostOptions{Limit: 2}, "bc"},
+		{"offset and limit", ListPostOptions{Offset: 1, Limit: 1}, "c"},
+		{"offset past the end", ListPostOptions{Offset: 3}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(ids(tc.opts.sortAndPage(newPosts())), tc.want)
+		})
+	}
+
+	t.Run("invalid sort key", func(t *testing.T) {
+		is := is.New(t)
+		_, err := ParsePostSortKey("size")
+		is.True(err != nil)
+	})
+}
+
+func TestChangePost(t *testing.T) {
+	svc := &racingUpdates{PostsService: NewMemoryPostsService()}
+
+	create := func(is *is.I) *Post {
+		post := &Post{Title: "foo", Content: "bar", Tags: []Tag{"a"}}
+		is.NoErr(svc.CreatePost(post))
+		return post
+	}
+
+	t.Run("changes are applied to posts changed in the meantime", func(t *testing.T) {
+		is := is.New(t)
+		post := create(is)
+
+		svc.races = maxChangeAttempts - 1
+		_, err := TogglePostState(svc, post.ID, PinnedTag)
+		is.NoErr(err)
+		svc.races = maxChangeAttempts - 1
+		_, err = MovePost(svc, post.ID, "x")
+		is.NoErr(err)
+		svc.races = maxChangeAttempts - 1
+		_, err = RenameTag(svc, "a", "b")
+		is.NoErr(err)
+
+		p, err := svc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "bar"+strings.Repeat("!", 3*(maxChangeAttempts-1)))
+		is.Equal(p.Tags, []Tag{"b", PinnedTag, DirTag("x")})
+	})
+
+	t.Run("posts that keep changing are a conflict", func(t *testing.T) {
+		is := is.New(t)
+		post := create(is)
+
+		svc.races = maxChangeAttempts
+		_, err := MovePost(svc, post.ID, "x")
+		is.True(errors.Is(err, ErrConflict))
+
+		p, err := svc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Content, "bar"+strings.Repeat("!", maxChangeAttempts))
+		is.Equal(p.Tags, []Tag{"a"})
+	})
+}
+
+// racingUpdates is a PostsService where someone else changes a post right
+// before each of the next races updates.
+type racingUpdates struct {
+	PostsService
+	races int
+}
+
+func (svc *racingUpdates) UpdatePost(p *Post) error {
+	if svc.races > 0 {
+		svc.races--
+		other, err := svc.PostsService.GetPost(p.ID)
+		if err != nil {
+			return err
+		}
+		other.Content += "!"
+		if err := svc.PostsService.UpdatePost(other); err != nil {
+			return err
+		}
+	}
+	return svc.PostsService.UpdatePost(p)
+}
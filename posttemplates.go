@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Posts with this tag are templates for new posts
+const TemplateTag Tag = "_template"
+
+// Variables in templates are written as `{{name}}`
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([\w][\w -]*?)\s*\}\}`)
+
+// Variables that are filled in without asking
+const (
+	TemplateVarDate   = "date"
+	TemplateVarTime   = "time"
+	TemplateVarFolder = "folder"
+)
+
+func isBuiltinTemplateVar(name string) bool {
+	switch name {
+	case TemplateVarDate, TemplateVarTime, TemplateVarFolder:
+		return true
+	}
+	return false
+}
+
+// ListTemplates returns all posts that are templates, by title.
+func ListTemplates(svc PostsService) ([]*Post, error) {
+	posts, err := svc.ListPosts(&ListPostOptions{
+		TagsFilter: []string{string(TemplateTag)},
+		SortBy:     SortByTitle,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ListTemplates: %w", err)
+	}
+	return posts, nil
+}
+
+// TemplateFields returns the names of the variables of a template that must
+// be asked for, in order of appearance.
+func TemplateFields(tmpl *Post) []string {
+	texts := []string{tmpl.Title, tmpl.Content}
+	for _, t := range tmpl.Tags {
+		texts = append(texts, string(t))
+	}
+
+	var fields []string
+	seen := make(map[string]bool)
+	for _, s := range texts {
+		for _, m := range templateVarPattern.FindAllStringSubmatch(s, -1) {
+			if name := m[1]; !isBuiltinTemplateVar(name) && !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		}
+	}
+	return fields
+}
+
+// expandTemplate replaces the variables in s with their values. Variables
+// without a value are left out.
+func expandTemplate(s string, vars map[string]string) string {
+	return templateVarPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return vars[templateVarPattern.FindStringSubmatch(m)[1]]
+	})
+}
+
+// NewPostFromTemplate returns a new, unsaved post with the title, tags and
+// content of a template, with all variables filled in from fields. The post
+// is placed in dir, if not empty, and in the folders of the template
+// otherwise.
+func NewPostFromTemplate(tmpl *Post, dir string, fields map[string]string, now time.Time) *Post {
+	dir = cleanDir(dir)
+	folder := dir
+	if dirs := tmpl.Dirs(); folder == "" && len(dirs) > 0 {
+		folder = cleanDir(dirs[0])
+	}
+
+	vars := map[string]string{
+		TemplateVarDate:   now.Format("2006-01-02"),
+		TemplateVarTime:   now.Format("15:04"),
+		TemplateVarFolder: folder,
+	}
+	for k, v := range fields {
+		if !isBuiltinTemplateVar(k) {
+			vars[k] = v
+		}
+	}
+
+	post := &Post{
+		Title:   strings.TrimSpace(expandTemplate(tmpl.Title, vars)),
+		Content: expandTemplate(tmpl.Content, vars),
+	}
+	for _, t := range tmpl.Tags {
+		if t == TemplateTag || (dir != "" && strings.HasPrefix(string(t), DirTagPrefix)) {
+			continue
+		}
+		post.Tags = append(post.Tags, Tag(expandTemplate(string(t), vars)))
+	}
+	if dir != "" {
+		post.Tags = append(post.Tags, DirTag(dir))
+	}
+
+	return post
+}
+
+// Prefix of the query parameters with the values of template fields
+const templateFieldParamPrefix = "field."
+
+// newPostFromRequest returns the post a new post form starts from. Templates
+// are given by ID in the `template` parameter, and filled in with the fields
+// in the other parameters. ready is false if the fields of the returned
+// template must be asked for first.
+func (app *App) newPostFromRequest(r *http.Request) (post *Post, tmpl *Post, ready bool, err error) {
+	q := r.URL.Query()
+	dir := q.Get("dir")
+
+	id := q.Get("template")
+	if id == "" {
+		// Links to missing posts suggest a title
+		post = &Post{Title: q.Get("title")}
+		if dir = cleanDir(dir); dir != "" {
+			post.Tags = []Tag{DirTag(dir)}
+		}
+		return post, nil, true, nil
+	}
+
+	tmpl, err = app.posts.GetPost(id)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	fields := make(map[string]string)
+	for k := range q {
+		if name, found := strings.CutPrefix(k, templateFieldParamPrefix); found {
+			fields[name] = q.Get(k)
+		}
+	}
+	// Fields are asked for once, but may be left empty
+	if len(fields) == 0 && len(TemplateFields(tmpl)) > 0 {
+		return nil, tmpl, false, nil
+	}
+
+	return NewPostFromTemplate(tmpl, dir, fields, time.Now()), tmpl, true, nil
+}
+
+// renderTemplateFields asks for the fields of a template before a new post
+// is created from it.
+func (app *App) renderTemplateFields(w http.ResponseWriter, r *http.Request, tmpl *Post) {
+	locals := app.buildLocals(struct {
+		Template    *Post
+		Dir         string
+		Fields      []string
+		ParamPrefix string
+	}{
+		Template:    tmpl,
+		Dir:         r.URL.Query().Get("dir"),
+		Fields:      TemplateFields(tmpl),
+		ParamPrefix: templateFieldParamPrefix,
+	})
+
+	if err := app.templates.ExecuteTemplate(w, "template-fields.html", locals); err != nil {
+		log.Printf("error: template: %v", err)
+	}
+}
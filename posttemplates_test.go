@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestNewPostFromTemplate(t *testing.T) {
+	tmpl := &Post{
+		Title:   "Incident {{date}} {{ Service }}",
+		Content: "# {{Service}} at {{time}}\nIn {{folder}}. Owner: {{owner}}. Not {{ a var!}}",
+		Tags:    []Tag{"incident", "_template", "svc/{{Service}}", "_dir:/ops/incidents"},
+	}
+	now := time.Date(2023, 4, 5, 13, 37, 0, 0, time.UTC)
+
+	t.Run("fields", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(TemplateFields(tmpl), []string{"Service", "owner"})
+	})
+
+	t.Run("expand in the template folder", func(t *testing.T) {
+		is := is.New(t)
+		p := NewPostFromTemplate(tmpl, "", map[string]string{"Service": "nginx", "date": "ignored"}, now)
+		is.Equal(p.ID, "")
+		is.Equal(p.Title, "Incident 2023-04-05 nginx")
+		is.Equal(p.Content, "# nginx at 13:37\nIn ops/incidents. Owner: . Not {{ a var!}}")
+		is.Equal(p.Tags, []Tag{"incident", "svc/nginx", "_dir:/ops/incidents"})
+	})
+
+	t.Run("expand in another folder", func(t *testing.T) {
+		is := is.New(t)
+		p := NewPostFromTemplate(tmpl, "/home/", nil, now)
+		is.True(strings.Contains(p.Content, "In home."))
+		is.Equal(p.Tags, []Tag{"incident", "svc/", "_dir:/home"})
+	})
+}
+
+func TestNewPostFromTemplateHandler(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	meeting := &Post{Title: "Meeting {{date}}", Content: "With {{who}}", Tags: []Tag{"_template"}}
+	is.NoErr(app.posts.CreatePost(meeting))
+	plain := &Post{Title: "Daily {{date}}", Tags: []Tag{"_template", "daily"}}
+	is.NoErr(app.posts.CreatePost(plain))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	t.Run("menu lists templates", func(t *testing.T) {
+		is := is.New(t)
+		body := get("/").Body.String()
+		is.True(strings.Contains(body, `href="/posts/?template=`+meeting.ID+`"`))
+		is.True(strings.Contains(body, `href="/posts/?template=`+plain.ID+`"`))
+	})
+
+	t.Run("fields are asked for", func(t *testing.T) {
+		is := is.New(t)
+		w := get("/posts/?template=" + meeting.ID + "&dir=work")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `name="field.who"`))
+		is.True(strings.Contains(w.Body.String(), `name="dir" value="work"`))
+	})
+
+	t.Run("fields are filled in", func(t *testing.T) {
+		is := is.New(t)
+		w := get("/posts/?template=" + meeting.ID + "&dir=work&" + url.QueryEscape("field.who") + "=Bob")
+		is.Equal(w.Code, 200)
+		body := w.Body.String()
+		is.True(strings.Contains(body, `value="Meeting `+time.Now().Format("2006-01-02")+`"`))
+		is.True(strings.Contains(body, ">With Bob</textarea>"))
+		is.True(strings.Contains(body, `value="_dir:/work"`))
+	})
+
+	t.Run("templates without fields", func(t *testing.T) {
+		is := is.New(t)
+		w := get("/posts/?template=" + plain.ID)
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `value="daily"`))
+	})
+
+	t.Run("missing template", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(get("/posts/?template=nope").Code, 404)
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A QueryError describes a malformed search query.
+type QueryError struct {
+	// The part of the query that could not be parsed
+	Term string
+	Msg  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Term, e.Msg)
+}
+
+type queryTerm struct {
+	raw    string
+	key    string
+	value  string
+	negate bool
+	quoted bool
+}
+
+// splitQuery splits a query into whitespace separated terms. Double quotes
+// group words into a single term, and can be used in filter values, like
+// `title:"foo bar"`. Within quotes, a backslash escapes a double quote or
+// another backslash.
+func splitQuery(q string) ([]queryTerm, error) {
+	var (
+		terms   []queryTerm
+		current strings.Builder
+		cur     queryTerm
+		inQuote bool
+		escaped bool
+		started bool
+	)
+
+	flush := func() {
+		if started {
+			cur.raw = current.String()
+			terms = append(terms, cur)
+		}
+		current.Reset()
+		cur = queryTerm{}
+		started = false
+	}
+
+	for _, r := range q {
+		switch {
+		case escaped:
+			if r != '"' && r != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && inQuote:
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+			cur.quoted = true
+			started = true
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if inQuote {
+		return nil, &QueryError{Term: q, Msg: "missing closing quote"}
+	}
+	flush()
+
+	for i, t := range terms {
+		s := t.raw
+		if strings.HasPrefix(s, "-") {
+			t.negate = true
+			s = s[1:]
+		}
+		if key, value, ok := strings.Cut(s, ":"); ok && isQueryKey(key) {
+			t.key, t.value = key, value
+		} else {
+			t.negate = false
+		}
+		terms[i] = t
+	}
+
+	return terms, nil
+}
+
+// quoteQueryValue returns a filter value of a query in double quotes, with
+// the double quotes and backslashes in it escaped.
+func quoteQueryValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Query returns the query term filtering posts by the tag, like
+// `tag:"foo bar"`.
+func (t Tag) Query() string {
+	return "tag:" + quoteQueryValue(string(t))
+}
+
+// TagQuery returns the query term filtering posts by the tag of a node of
+// a tag tree.
+func (node *Node) TagQuery() string {
+	return Tag(node.Path).Query()
+}
+
+func isQueryKey(key string) bool {
+	switch key {
+	case "tag", "title", "created", "modified", "dir":
+		return true
+	}
+	return false
+}
+
+// ParseQuery parses a search query into list options. Words that are not
+// filters make up the full-text search term. Supported filters:
+//
+//	tag:linux           posts tagged linux
+//	tag:"my \"tag\""    posts tagged my "tag"
+//	-tag:draft          posts not tagged draft
+//	title:"foo bar"     posts with foo bar in the title
+//	created:>2023-01-01 posts created after the given day
+//	modified:<7d        posts modified within the last 7 days
+//	dir:work/ops        posts in the work/ops folder, or its sub-folders
+//
+// Times can be compared with <, <=, > and >=, and are either dates or ages
+// in hours (h), days (d), weeks (w) or years (y). Without an operator, a date
+// matches that day and an age matches anything more recent.
+func ParseQuery(q string, now time.Time) (*ListPostOptions, error) {
+	terms, err := splitQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := new(ListPostOptions)
+	var search []string
+
+	for _, t := range terms {
+		if t.key == "" {
+			if t.quoted {
+				search = append(search, `"`+t.raw+`"`)
+			} else {
+				search = append(search, t.raw)
+			}
+			continue
+		}
+
+		if t.value == "" {
+			return nil, &QueryError{Term: t.key + ":", Msg: "missing value"}
+		}
+		if t.negate && t.key != "tag" {
+			return nil, &QueryError{Term: "-" + t.key + ":", Msg: "only tag: filters can be negated"}
+		}
+
+		switch t.key {
+		case "tag":
+			if t.negate {
+				opts.ExcludeTags = append(opts.ExcludeTags, t.value)
+			} else {
+				opts.TagsFilter = append(opts.TagsFilter, t.value)
+			}
+		case "title":
+			opts.TitleTerms = append(opts.TitleTerms, t.value)
+		case "dir":
+			if opts.Dir != "" {
+				return nil, &QueryError{Term: "dir:" + t.value, Msg: "only one dir: filter is allowed"}
+			}
+			opts.Dir = strings.Trim(t.value, TagPathSeparator)
+		case "created":
+			if err := parseTimeRange(t.value, now, &opts.CreatedAfter, &opts.CreatedBefore); err != nil {
+				return nil, &QueryError{Term: "created:" + t.value, Msg: err.Error()}
+			}
+		case "modified":
+			if err := parseTimeRange(t.value, now, &opts.ModifiedAfter, &opts.ModifiedBefore); err != nil {
+				return nil, &QueryError{Term: "modified:" + t.value, Msg: err.Error()}
+			}
+		}
+	}
+
+	opts.SearchTerm = strings.Join(search, " ")
+	return opts, nil
+}
+
+const queryDateLayout = "2006-01-02"
+
+// parseTimeRange narrows the range [after, before) by a time comparison like
+// `>2023-01-01` or `<7d`.
+func parseTimeRange(s string, now time.Time, after, before *time.Time) error {
+	var op string
+	for _, o := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(s, o) {
+			op, s = o, s[len(o):]
+			break
+		}
+	}
+
+	// Narrow the range, keeping any previous bound that is stricter
+	setAfter := func(t time.Time) {
+		if after.IsZero() || t.After(*after) {
+			*after = t
+		}
+	}
+	setBefore := func(t time.Time) {
+		if before.IsZero() || t.Before(*before) {
+			*before = t
+		}
+	}
+
+	if age, err := parseAge(s); err == nil {
+		// Ages count backwards, so < means more recent
+		t := now.Add(-age)
+		switch op {
+		case "", "<", "<=":
+			setAfter(t)
+		case ">", ">=":
+			setBefore(t)
+		}
+		return nil
+	}
+
+	day, err := time.ParseInLocation(queryDateLayout, s, now.Location())
+	if err != nil {
+		return errors.New("expected a date like YYYY-MM-DD or an age like 7d")
+	}
+	next := day.AddDate(0, 0, 1)
+
+	switch op {
+	case "":
+		setAfter(day)
+		setBefore(next)
+	case ">":
+		setAfter(next)
+	case ">=":
+		setAfter(day)
+	case "<":
+		setBefore(day)
+	case "<=":
+		setBefore(next)
+	}
+	return nil
+}
+
+// parseAge parses durations like 12h, 7d, 2w and 1y.
+func parseAge(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	unit := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("free text only", func(t *testing.T) {
+		is := is.New(t)
+		opts, err := ParseQuery(`nginx  "reverse proxy" http://example.com`, now)
+		is.NoErr(err)
+		is.Equal(opts, &ListPostOptions{SearchTerm: `nginx "reverse proxy" http://example.com`})
+	})
+
+	t.Run("all filters", func(t *testing.T) {
+		is := is.New(t)
+		opts, err := ParseQuery(`tag:linux -tag:draft title:"nginx conf" created:>2023-01-01 modified:<7d dir:/work/ops/ foo`, now)
+		is.NoErr(err)
+		is.Equal(opts, &ListPostOptions{
+			SearchTerm:    "foo",
+			TagsFilter:    []string{"linux"},
+			ExcludeTags:   []string{"draft"},
+			TitleTerms:    []string{"nginx conf"},
+			Dir:           "work/ops",
+			CreatedAfter:  day(2023, 1, 2),
+			ModifiedAfter: now.AddDate(0, 0, -7),
+		})
+	})
+
+	t.Run("quoted tags", func(t *testing.T) {
+		for _, tag := range []Tag{"linux", "foo bar", `say "hi"`, `back\slash`, `a\"b`, "-tag:x"} {
+			t.Run(string(tag), func(t *testing.T) {
+				is := is.New(t)
+				opts, err := ParseQuery(tag.Query()+" -"+tag.Query(), now)
+				is.NoErr(err)
+				is.Equal(opts.TagsFilter, []string{string(tag)})
+				is.Equal(opts.ExcludeTags, []string{string(tag)})
+				is.Equal(opts.SearchTerm, "")
+			})
+		}
+	})
+
+	t.Run("time comparisons", func(t *testing.T) {
+		for _, tc := range []struct {
+			q      string
+			after  time.Time
+			before time.Time
+		}{
+			{"created:2023-01-01", day(2023, 1, 1), day(2023, 1, 2)},
+			{"created:>=2023-01-01", day(2023, 1, 1), time.Time{}},
+			{"created:<2023-01-01", time.Time{}, day(2023, 1, 1)},
+			{"created:<=2023-01-01", time.Time{}, day(2023, 1, 2)},
+			{"created:12h", now.Add(-12 * time.Hour), time.Time{}},
+			{"created:>2w", time.Time{}, now.AddDate(0, 0, -14)},
+			{"created:>2023-01-01 created:<1y", day(2023, 1, 2), time.Time{}},
+		} {
+			t.Run(tc.q, func(t *testing.T) {
+				is := is.New(t)
+				opts, err := ParseQuery(tc.q, now)
+				is.NoErr(err)
+				is.Equal(opts.CreatedAfter, tc.after)
+				is.Equal(opts.CreatedBefore, tc.before)
+			})
+		}
+	})
+
+	t.Run("malformed queries", func(t *testing.T) {
+		for q, msg := range map[string]string{
+			`title:"foo`:          "missing closing quote",
+			`tag:`:                "missing value",
+			`-title:foo`:          "only tag: filters can be negated",
+			`created:yesterday`:   "expected a date like YYYY-MM-DD or an age like 7d",
+			`modified:<2023-13-1`: "expected a date like YYYY-MM-DD or an age like 7d",
+			`dir:a dir:b`:         "only one dir: filter is allowed",
+		} {
+			t.Run(q, func(t *testing.T) {
+				is := is.New(t)
+				_, err := ParseQuery(q, now)
+				var qerr *QueryError
+				is.True(errors.As(err, &qerr))
+				is.Equal(qerr.Msg, msg)
+			})
+		}
+	})
+}
+
+func TestListPostOptionsMatches(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	post := &Post{
+		Title:        "Nginx Config",
+		Tags:         []Tag{"linux", "lang/go/testing", "_dir:/work/ops/web"},
+		CreatedTime:  created,
+		ModifiedTime: created.AddDate(0, 1, 0),
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts ListPostOptions
+		want bool
+	}{
+		{"no filters", ListPostOptions{}, true},
+		{"tag", ListPostOptions{TagsFilter: []string{"linux"}}, true},
+		{"missing tag", ListPostOptions{TagsFilter: []string{"mac"}}, false},
+		{"excluded tag", ListPostOptions{ExcludeTags: []string{"linux"}}, false},
+		{"parent tag", ListPostOptions{TagsFilter: []string{"lang/go"}}, true},
+		{"tag prefix is not a parent", ListPostOptions{TagsFilter: []string{"lang/g"}}, false},
+		{"child tag", ListPostOptions{TagsFilter: []string{"lang/go/testing/fuzz"}}, false},
+		{"excluded parent tag", ListPostOptions{ExcludeTags: []string{"lang"}}, false},
+		{"not archived", ListPostOptions{ExcludeArchived: true}, true},
+		{"not a draft", ListPostOptions{ExcludeDrafts: true}, true},
+		{"any tag", ListPostOptions{TagsFilter: []string{"linux", "mac"}, TagMatch: TagMatchAny}, true},
+		{"all tags", ListPostOptions{TagsFilter: []string{"linux", "mac"}, TagMatch: TagMatchAll}, false},
+		{"all tags present", ListPostOptions{TagsFilter: []string{"linux", "_dir:/work/ops/web"}, TagMatch: TagMatchAll}, true},
+		{"none of the tags", ListPostOptions{TagsFilter: []string{"linux", "mac"}, TagMatch: TagMatchNone}, false},
+		{"none of the tags absent", ListPostOptions{TagsFilter: []string{"windows", "mac"}, TagMatch: TagMatchNone}, true},
+		{"title", ListPostOptions{TitleTerms: []string{"nginx", "CONF"}}, true},
+		{"wrong title", ListPostOptions{TitleTerms: []string{"nginx", "apache"}}, false},
+		{"parent dir", ListPostOptions{Dir: "work/ops"}, true},
+		{"same dir", ListPostOptions{Dir: "/work/ops/web/"}, true},
+		{"dir prefix is not a parent", ListPostOptions{Dir: "work/op"}, false},
+		{"created in range", ListPostOptions{CreatedAfter: created, CreatedBefore: created.Add(time.Hour)}, true},
+		{"created too early", ListPostOptions{CreatedAfter: created.Add(time.Second)}, false},
+		{"modified too late", ListPostOptions{ModifiedBefore: created.AddDate(0, 1, 0)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tc.opts.matches(post), tc.want)
+		})
+	}
+}
+
+func TestParseTagMatchMode(t *testing.T) {
+	is := is.New(t)
+
+	m, err := ParseTagMatchMode("")
+	is.NoErr(err)
+	is.Equal(m, TagMatchAny)
+
+	m, err = ParseTagMatchMode("none")
+	is.NoErr(err)
+	is.Equal(m, TagMatchNone)
+
+	_, err = ParseTagMatchMode("some")
+	is.True(err != nil)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Name of the directory, relative to the posts root, where previous versions
+// of posts are kept. Every post has its own sub-directory.
+const RevisionsDirName = ".revisions"
+
+// Revision ID referring to the current version of a post.
+const CurrentRevisionID = "current"
+
+// A Revision is a snapshot of a post as it was at ModifiedTime.
+type Revision struct {
+	ID           string
+	PostID       string
+	Title        string
+	Content      string
+	Tags         []Tag
+	ModifiedTime time.Time
+}
+
+// Revision returns a snapshot of the current version of the post.
+func (p *Post) Revision() *Revision {
+	return &Revision{
+		ID:           CurrentRevisionID,
+		PostID:       p.ID,
+		Title:        p.Title,
+		Content:      p.Content,
+		Tags:         append([]Tag(nil), p.Tags...),
+		ModifiedTime: p.ModifiedTime,
+	}
+}
+
+// Version identifies the version of the post the revision is a snapshot of,
+// like Post.Version.
+func (r *Revision) Version() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", r.PostID, r.Title, r.Content)
+	for _, t := range r.Tags {
+		fmt.Fprintf(h, "%s\x00", t)
+	}
+	fmt.Fprintf(h, "%d", r.ModifiedTime.UnixNano())
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (svc postsService) revisionsDir(postID string) string {
+	return path.Join(svc.root, RevisionsDirName, postID)
+}
+
+// Stores a snapshot of the post as a new revision.
+func (svc postsService) saveRevision(p *Post) error {
+	id, err := ksuid.NewRandomWithTime(p.ModifiedTime)
+	if err != nil {
+		return err
+	}
+
+	rev := p.Revision()
+	rev.ID = id.String()
+
+	return svc.writeRevision(rev)
+}
+
+func (svc postsService) writeRevision(rev *Revision) error {
+	b, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+
+	dir := svc.revisionsDir(rev.PostID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path.Join(dir, rev.ID), b, DefaultFileMode)
+}
+
+// Returns all previous versions of a post, newest first. The current version
+// of the post is not included.
+func (svc postsService) ListRevisions(postID string) ([]*Revision, error) {
+	entries, err := os.ReadDir(svc.revisionsDir(postID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("ListRevisions: %w", err)
+	}
+
+	var revs []*Revision
+	for _, e := range entries {
+		// Revisions being written, or left over by a crash
+		if isTempFile(e.Name()) {
+			continue
+		}
+		rev, err := svc.GetRevision(postID, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("ListRevisions: %w", err)
+		}
+		revs = append(revs, rev)
+	}
+
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].ModifiedTime.After(revs[j].ModifiedTime)
+	})
+
+	return revs, nil
+}
+
+// Returns a single previous version of a post.
+func (svc postsService) GetRevision(postID, revisionID string) (*Revision, error) {
+	if !plainIDPattern.MatchString(revisionID) {
+		return nil, fmt.Errorf("GetRevision: revision %q: %w", revisionID, fs.ErrNotExist)
+	}
+
+	b, err := os.ReadFile(path.Join(svc.revisionsDir(postID), revisionID))
+	if err != nil {
+		return nil, fmt.Errorf("GetRevision: %w", err)
+	}
+
+	rev := new(Revision)
+	if err := json.Unmarshal(b, rev); err != nil {
+		return nil, fmt.Errorf("GetRevision: %w", err)
+	}
+
+	return rev, nil
+}
+
+// getRevision returns a revision of a post, or its current version if
+// revisionID is CurrentRevisionID.
+func getRevision(svc PostsService, postID, revisionID string) (*Revision, error) {
+	if revisionID == CurrentRevisionID {
+		post, err := svc.GetPost(postID)
+		if err != nil {
+			return nil, err
+		}
+		return post.Revision(), nil
+	}
+	return svc.GetRevision(postID, revisionID)
+}
+
+// RestoreRevision replaces the title, content and tags of a post with those
+// of a previous revision. The version being replaced is itself kept as a new
+// revision.
+func RestoreRevision(svc PostsService, postID, revisionID string) (*Post, error) {
+	rev, err := svc.GetRevision(postID, revisionID)
+	if err != nil {
+		return nil, fmt.Errorf("RestoreRevision: %w", err)
+	}
+
+	post, err := svc.GetPost(postID)
+	if err != nil {
+		return nil, fmt.Errorf("RestoreRevision: %w", err)
+	}
+
+	post.Title = rev.Title
+	post.Content = rev.Content
+	post.Tags = append([]Tag(nil), rev.Tags...)
+
+	if err := svc.UpdatePost(post); err != nil {
+		return nil, fmt.Errorf("RestoreRevision: %w", err)
+	}
+
+	return post, nil
+}
+
+func (app *App) HistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		post, err := app.posts.GetPost(postID)
+		if err != nil {
+			log.Printf("error: HistoryHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 404)
+			return
+		}
+
+		revs, err := app.posts.ListRevisions(postID)
+		if err != nil {
+			log.Printf("error: HistoryHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 500)
+			return
+		}
+
+		locals := app.buildLocals(struct {
+			Post      *Post
+			Revisions []*Revision
+		}{
+			Post:      post,
+			Revisions: append([]*Revision{post.Revision()}, revs...),
+		})
+
+		if err := app.templates.ExecuteTemplate(w, "history.html", locals); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
+
+func (app *App) DiffHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		fromID, toID := r.FormValue("from"), r.FormValue("to")
+		if toID == "" {
+			toID = CurrentRevisionID
+		}
+
+		from, err := getRevision(app.posts, postID, fromID)
+		if err != nil {
+			log.Printf("error: DiffHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 404)
+			return
+		}
+		to, err := getRevision(app.posts, postID, toID)
+		if err != nil {
+			log.Printf("error: DiffHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 404)
+			return
+		}
+
+		locals := app.buildLocals(struct {
+			PostID string
+			From   *Revision
+			To     *Revision
+			Diff   []DiffLine
+		}{
+			PostID: postID,
+			From:   from,
+			To:     to,
+			Diff:   DiffLines(from.Content, to.Content),
+		})
+
+		if err := app.templates.ExecuteTemplate(w, "diff.html", locals); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
+
+func (app *App) RestoreRevisionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		revID, _ := r.Context().Value("rev").(string)
+
+		if _, err := RestoreRevision(app.posts, postID, revID); err != nil {
+			log.Printf("error: RestoreRevisionHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestRevisions(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	post := &Post{Title: "v1", Content: "one", Tags: []Tag{"a"}}
+	is.NoErr(svc.CreatePost(post))
+
+	t.Run("a new post has no revisions", func(t *testing.T) {
+		is := is.New(t)
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 0)
+	})
+
+	t.Run("updates keep the previous version", func(t *testing.T) {
+		is := is.New(t)
+
+		post.Title, post.Content, post.Tags = "v2", "two", []Tag{"b"}
+		is.NoErr(svc.UpdatePost(post))
+		post.Title, post.Content = "v3", "three"
+		is.NoErr(svc.UpdatePost(post))
+
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 2)
+		is.Equal(revs[0].Title, "v2") // newest first
+		is.Equal(revs[1].Title, "v1")
+		is.Equal(revs[1].Content, "one")
+		is.Equal(revs[1].Tags, []Tag{"a"})
+		is.Equal(revs[1].PostID, post.ID)
+
+		rev, err := svc.GetRevision(post.ID, revs[1].ID)
+		is.NoErr(err)
+		is.Equal(rev, revs[1])
+	})
+
+	t.Run("getRevision returns the current version", func(t *testing.T) {
+		is := is.New(t)
+		rev, err := getRevision(svc, post.ID, CurrentRevisionID)
+		is.NoErr(err)
+		is.Equal(rev.Title, "v3")
+		is.Equal(rev.ID, CurrentRevisionID)
+	})
+
+	t.Run("revision IDs are not paths", func(t *testing.T) {
+		is := is.New(t)
+		_, err := svc.GetRevision(post.ID, "../../"+post.ID)
+		is.True(errors.Is(err, fs.ErrNotExist))
+
+		app := NewApp(svc, ":1337")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+			"/posts/"+post.ID+"/diff?from="+url.QueryEscape("../../"+post.ID), nil))
+		is.Equal(w.Code, http.StatusNotFound)
+	})
+
+	t.Run("restoring a revision creates a new revision", func(t *testing.T) {
+		is := is.New(t)
+
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+
+		restored, err := RestoreRevision(svc, post.ID, revs[1].ID)
+		is.NoErr(err)
+		is.Equal(restored.Title, "v1")
+
+		p, err := svc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "v1")
+		is.Equal(p.Content, "one")
+		is.Equal(p.Tags, []Tag{"a"})
+
+		revs, err = svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 3)
+		is.Equal(revs[0].Title, "v3")
+	})
+
+	t.Run("revisions are removed when the post is purged", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(post.ID))
+		is.NoErr(svc.PurgePost(post.ID))
+
+		revs, err := svc.ListRevisions(post.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 0)
+	})
+}
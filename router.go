@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"regexp"
+	"slices"
 )
 
 type Router struct {
@@ -31,7 +32,7 @@ func (r *Router) Post(pat string, handler http.Handler) {
 	r.routes = append(r.routes, route)
 }
 
-// Get adds a route that matches the HTTP GET method.
+// Patch adds a route that matches the HTTP PATCH method.
 func (r *Router) Patch(pat string, handler http.Handler) {
 	route := Route{
 		method:  http.MethodPatch,
@@ -41,6 +42,50 @@ func (r *Router) Patch(pat string, handler http.Handler) {
 	r.routes = append(r.routes, route)
 }
 
+// Put adds a route that matches the HTTP PUT method.
+func (r *Router) Put(pat string, handler http.Handler) {
+	route := Route{
+		method:  http.MethodPut,
+		pattern: regexp.MustCompile(pat),
+		handler: handler,
+	}
+	r.routes = append(r.routes, route)
+}
+
+// Delete adds a route that matches the HTTP DELETE method.
+func (r *Router) Delete(pat string, handler http.Handler) {
+	route := Route{
+		method:  http.MethodDelete,
+		pattern: regexp.MustCompile(pat),
+		handler: handler,
+	}
+	r.routes = append(r.routes, route)
+}
+
+// Any adds a route that matches all HTTP methods.
+func (r *Router) Any(pat string, handler http.Handler) {
+	route := Route{
+		pattern: regexp.MustCompile(pat),
+		handler: handler,
+	}
+	r.routes = append(r.routes, route)
+}
+
+// Methods returns the HTTP methods of the routes matching a path, other than
+// those matching all methods.
+func (router *Router) Methods(path string) []string {
+	var methods []string
+	for _, route := range router.routes {
+		if route.method == "" || slices.Contains(methods, route.method) {
+			continue
+		}
+		if m := route.pattern.FindStringSubmatch(path); m != nil && !(len(m) == 1 && m[0] == "") {
+			methods = append(methods, route.method)
+		}
+	}
+	return methods
+}
+
 // Get adds a route that matches the HTTP GET method.
 func (r *Router) Use(handler func(http.Handler) http.Handler) {
 	r.middlewares = append(r.middlewares, handler)
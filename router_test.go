@@ -119,4 +119,28 @@ func TestRouterMethods(t *testing.T) {
 		is.Equal(string(body), "hello, bar")
 		is.Equal(resp.StatusCode, 200)
 	})
+
+	t.Run("Any matches all methods", func(t *testing.T) {
+		is := is.New(t)
+		router := Router{}
+		hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})
+		router.Get("^/foo$", hello)
+		router.Patch("^/foo$", hello)
+		router.Any("^/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("any"))
+		}))
+
+		req := httptest.NewRequest(http.MethodPut, "/foo", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+		body, err := io.ReadAll(w.Result().Body)
+		is.NoErr(err)
+
+		is.Equal(string(body), "any")
+		is.Equal(router.Methods("/foo"), []string{http.MethodGet, http.MethodPatch})
+		is.Equal(len(router.Methods("/bar")), 0)
+	})
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball/english"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// Weight of a term occurring in the title compared to in the content
+	titleBoost = 3.0
+)
+
+type searchField int
+
+const (
+	fieldTitle searchField = iota
+	fieldContent
+	numSearchFields
+)
+
+// A token is a single normalized word of a text. Start and End are byte
+// offsets of the original word in the text.
+type token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// stem reduces a word to its stem, so different forms of a word match each
+// other.
+func stem(word string) string {
+	return english.Stem(word, false)
+}
+
+// tokenize splits a text into stemmed, lower case words.
+func tokenize(s string) []token {
+	var (
+		tokens []token
+		start  = -1
+	)
+	for i, r := range s {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{Term: stem(s[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{Term: stem(s[start:]), Start: start, End: len(s)})
+	}
+	return tokens
+}
+
+// A searchQuery matches posts containing all of its clauses. Each clause is
+// a single term, or a phrase of terms that must occur next to each other.
+type searchQuery [][]string
+
+// parseSearchQuery parses a free text query. Text in double quotes is
+// treated as a phrase.
+func parseSearchQuery(q string) searchQuery {
+	var query searchQuery
+	for i, part := range strings.Split(q, `"`) {
+		tokens := tokenize(part)
+		if i%2 == 1 {
+			// Inside quotes
+			var phrase []string
+			for _, t := range tokens {
+				phrase = append(phrase, t.Term)
+			}
+			if len(phrase) > 0 {
+				query = append(query, phrase)
+			}
+			continue
+		}
+		for _, t := range tokens {
+			query = append(query, []string{t.Term})
+		}
+	}
+	return query
+}
+
+// terms returns the distinct terms of all clauses.
+func (q searchQuery) terms() map[string]bool {
+	terms := make(map[string]bool)
+	for _, clause := range q {
+		for _, t := range clause {
+			terms[t] = true
+		}
+	}
+	return terms
+}
+
+type posting struct {
+	// Token positions of the term per field
+	positions [numSearchFields][]int
+}
+
+type searchDoc struct {
+	// Number of tokens per field
+	length [numSearchFields]int
+	// Distinct terms of the document, needed for removal
+	terms []string
+}
+
+// searchIndex is an inverted index of the title and content of posts.
+type searchIndex struct {
+	docs     map[string]*searchDoc
+	postings map[string]map[string]*posting // term -> post ID -> posting
+	totalLen [numSearchFields]int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:     make(map[string]*searchDoc),
+		postings: make(map[string]map[string]*posting),
+	}
+}
+
+// add indexes a post, replacing any previously indexed version.
+func (si *searchIndex) add(p *Post) {
+	si.remove(p.ID)
+
+	doc := new(searchDoc)
+	for field, text := range [numSearchFields]string{p.Title, p.Content} {
+		tokens := tokenize(text)
+		doc.length[field] = len(tokens)
+		si.totalLen[field] += len(tokens)
+
+		for pos, t := range tokens {
+			docs, ok := si.postings[t.Term]
+			if !ok {
+				docs = make(map[string]*posting)
+				si.postings[t.Term] = docs
+			}
+			pst, ok := docs[p.ID]
+			if !ok {
+				pst = new(posting)
+				docs[p.ID] = pst
+				doc.terms = append(doc.terms, t.Term)
+			}
+			pst.positions[field] = append(pst.positions[field], pos)
+		}
+	}
+
+	si.docs[p.ID] = doc
+}
+
+func (si *searchIndex) remove(id string) {
+	doc, ok := si.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range doc.terms {
+		delete(si.postings[term], id)
+		if len(si.postings[term]) == 0 {
+			delete(si.postings, term)
+		}
+	}
+	for field := range doc.length {
+		si.totalLen[field] -= doc.length[field]
+	}
+	delete(si.docs, id)
+}
+
+// clauseFrequencies returns, for every post matching a clause, the number of
+// occurrences of the clause per field.
+func (si *searchIndex) clauseFrequencies(clause []string) map[string][numSearchFields]int {
+	freqs := make(map[string][numSearchFields]int)
+
+	for id, first := range si.postings[clause[0]] {
+		var tf [numSearchFields]int
+		for field := range first.positions {
+		next:
+			for _, pos := range first.positions[field] {
+				for i, term := range clause[1:] {
+					pst, ok := si.postings[term][id]
+					if !ok || !containsInt(pst.positions[field], pos+i+1) {
+						continue next
+					}
+				}
+				tf[field]++
+			}
+		}
+		if tf[fieldTitle]+tf[fieldContent] > 0 {
+			freqs[id] = tf
+		}
+	}
+
+	return freqs
+}
+
+func containsInt(sorted []int, x int) bool {
+	i := sort.SearchInts(sorted, x)
+	return i < len(sorted) && sorted[i] == x
+}
+
+// search returns the IDs of all posts matching the query, best match first.
+// Posts are ranked with BM25, treating title and content as separately
+// weighted fields.
+func (si *searchIndex) search(q searchQuery) []string {
+	if len(q) == 0 || len(si.docs) == 0 {
+		return nil
+	}
+
+	var avgLen [numSearchFields]float64
+	for field := range avgLen {
+		avgLen[field] = math.Max(1, float64(si.totalLen[field])/float64(len(si.docs)))
+	}
+
+	var scores map[string]float64
+	for _, clause := range q {
+		freqs := si.clauseFrequencies(clause)
+
+		df := float64(len(freqs))
+		n := float64(len(si.docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		clauseScores := make(map[string]float64, len(freqs))
+		for id, tf := range freqs {
+			// All clauses must match
+			if scores != nil {
+				if _, ok := scores[id]; !ok {
+					continue
+				}
+			}
+
+			doc := si.docs[id]
+			var wtf float64
+			for field, boost := range [numSearchFields]float64{titleBoost, 1} {
+				norm := 1 - bm25B + bm25B*float64(doc.length[field])/avgLen[field]
+				wtf += boost * float64(tf[field]) / norm
+			}
+			clauseScores[id] = scores[id] + idf*wtf*(bm25K1+1)/(wtf+bm25K1)
+		}
+		scores = clauseScores
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// Number of words shown in a snippet
+const snippetWords = 30
+
+// Snippet returns an excerpt of content around the best match of a search
+// query, with matching words highlighted.
+func Snippet(content, query string) template.HTML {
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	terms := parseSearchQuery(query).terms()
+	matches := make([]bool, len(tokens))
+	for i, t := range tokens {
+		matches[i] = terms[t.Term]
+	}
+
+	// Find the window of words with the most matches
+	var (
+		best, count, bestCount int
+		size                   = snippetWords
+	)
+	if size > len(tokens) {
+		size = len(tokens)
+	}
+	for i := range tokens {
+		if matches[i] {
+			count++
+		}
+		if i >= size && matches[i-size] {
+			count--
+		}
+		if i >= size-1 && count > bestCount {
+			best, bestCount = i-size+1, count
+		}
+	}
+	// Start the snippet a few words before the first match
+	for i := best; i < best+size; i++ {
+		if matches[i] {
+			best = i - 3
+			if best < 0 {
+				best = 0
+			}
+			if best+size > len(tokens) {
+				best = len(tokens) - size
+			}
+			break
+		}
+	}
+	window := tokens[best : best+size]
+
+	var sb strings.Builder
+	if best > 0 {
+		sb.WriteString("… ")
+	}
+	pos := window[0].Start
+	for i, t := range window {
+		sb.WriteString(template.HTMLEscapeString(content[pos:t.Start]))
+		word := template.HTMLEscapeString(content[t.Start:t.End])
+		if matches[best+i] {
+			sb.WriteString("<mark>" + word + "</mark>")
+		} else {
+			sb.WriteString(word)
+		}
+		pos = t.End
+	}
+	if best+size < len(tokens) {
+		sb.WriteString(" …")
+	}
+
+	return template.HTML(sb.String())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTokenize(t *testing.T) {
+	is := is.New(t)
+
+	tokens := tokenize("Running the nginx-servers, ÆØÅ!")
+	is.Equal(tokens, []token{
+		{Term: "run", Start: 0, End: 7},
+		{Term: "the", Start: 8, End: 11},
+		{Term: "nginx", Start: 12, End: 17},
+		{Term: "server", Start: 18, End: 25},
+		{Term: "æøå", Start: 27, End: 33},
+	})
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	is := is.New(t)
+
+	q := parseSearchQuery(`foo "reverse proxies" bar "" "`)
+	is.Equal(q, searchQuery{{"foo"}, {"revers", "proxi"}, {"bar"}})
+	is.Equal(len(parseSearchQuery(" -- ")), 0)
+}
+
+func TestSearchIndex(t *testing.T) {
+	si := newSearchIndex()
+	si.add(&Post{ID: "1", Title: "Nginx", Content: "A reverse proxy configuration"})
+	si.add(&Post{ID: "2", Title: "Proxies", Content: "Setting up nginx as a reverse proxy. Nginx is fast."})
+	si.add(&Post{ID: "3", Title: "Cooking", Content: "Proxy of reverse"})
+
+	search := func(q string) []string {
+		return si.search(parseSearchQuery(q))
+	}
+
+	t.Run("all terms must match", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(len(search("nginx proxy")), 2)
+		is.Equal(len(search("nginx cooking")), 0)
+	})
+
+	t.Run("title matches are ranked higher", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(search("nginx")[0], "1")
+		is.Equal(search("proxy")[0], "2")
+	})
+
+	t.Run("words match regardless of form", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(len(search("configured")), 1)
+	})
+
+	t.Run("phrases must match in order", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(len(search(`"reverse proxy"`)), 2)
+		is.Equal(search(`"proxy of"`), []string{"3"})
+		is.Equal(len(search(`"proxy reverse"`)), 0)
+	})
+
+	t.Run("removed posts are not found", func(t *testing.T) {
+		is := is.New(t)
+		si.add(&Post{ID: "1", Title: "Replaced"})
+		is.Equal(search("nginx"), []string{"2"})
+		si.remove("2")
+		is.Equal(len(search("nginx")), 0)
+		_, ok := si.postings["nginx"]
+		is.True(!ok) // unused terms are cleaned up
+	})
+}
+
+func TestSnippet(t *testing.T) {
+	t.Run("matches are highlighted and escaped", func(t *testing.T) {
+		is := is.New(t)
+		s := Snippet("Use <b>nginx</b> as a proxy", "proxies")
+		is.Equal(string(s), "Use &lt;b&gt;nginx&lt;/b&gt; as a <mark>proxy</mark>")
+	})
+
+	t.Run("long content is truncated around the match", func(t *testing.T) {
+		is := is.New(t)
+		content := strings.Repeat("word ", 100) + "needle " + strings.Repeat("word ", 100)
+		s := string(Snippet(content, "needle"))
+		is.True(strings.HasPrefix(s, "… word word word <mark>needle</mark> word"))
+		is.True(strings.HasSuffix(s, "word …"))
+	})
+
+	t.Run("no matches shows the beginning", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(string(Snippet("foo bar", "baz")), "foo bar")
+	})
+}
+
+func TestSearchPosts(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+	is.NoErr(svc.CreatePost(&Post{Title: "about go", Content: "testing in go", Tags: []Tag{"a"}}))
+	is.NoErr(svc.CreatePost(&Post{Title: "tests", Content: "we test a lot", Tags: []Tag{"b"}}))
+
+	posts, err := svc.ListPosts(&ListPostOptions{SearchTerm: "test"})
+	is.NoErr(err)
+	is.Equal(len(posts), 2)
+	is.Equal(posts[0].Title, "tests")
+
+	posts, err = svc.ListPosts(&ListPostOptions{SearchTerm: "test", TagsFilter: []string{"a"}})
+	is.NoErr(err)
+	is.Equal(len(posts), 1)
+	is.Equal(posts[0].Title, "about go")
+
+	t.Run("updated posts are reindexed", func(t *testing.T) {
+		is := is.New(t)
+		posts[0].Content = "nothing here"
+		posts[0].Title = "nothing"
+		is.NoErr(svc.UpdatePost(posts[0]))
+
+		posts, err := svc.ListPosts(&ListPostOptions{SearchTerm: "test"})
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+	})
+}
@@ -0,0 +1,965 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"modernc.org/sqlite"
+)
+
+// Name of the SQLite database file in the data dir. Like other app data, it
+// is hidden from the file storage.
+const SQLiteFileName = ".posts.db"
+
+// sqliteMigrations bring the database schema up to date. They are applied in
+// order, and the number of applied migrations is kept in the user_version of
+// the database. Released migrations must never change; add a new one
+// instead.
+var sqliteMigrations = []string{
+	// 1: posts with their tags, revisions and links, folder settings, and
+	// full-text search of titles and content
+	`CREATE TABLE posts (
+		seq INTEGER PRIMARY KEY,
+		id TEXT NOT NULL UNIQUE,
+		title TEXT NOT NULL,
+		-- Normalized title, to resolve wiki links
+		title_key TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created INTEGER NOT NULL,
+		modified INTEGER NOT NULL,
+		-- Time the post was moved to the trash
+		deleted INTEGER
+	);
+	CREATE INDEX posts_title_key ON posts (title_key, id);
+
+	CREATE TABLE tags (
+		post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		PRIMARY KEY (post_id, position)
+	);
+	CREATE INDEX tags_name ON tags (name);
+
+	CREATE TABLE revisions (
+		id TEXT PRIMARY KEY,
+		post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		-- JSON array
+		tags TEXT NOT NULL,
+		modified INTEGER NOT NULL
+	);
+	CREATE INDEX revisions_post_id ON revisions (post_id, modified);
+
+	CREATE TABLE links (
+		post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+		target TEXT NOT NULL,
+		-- Normalized target
+		target_key TEXT NOT NULL
+	);
+	CREATE INDEX links_post_id ON links (post_id);
+	CREATE INDEX links_target_key ON links (target_key);
+
+	CREATE TABLE folders (
+		path TEXT PRIMARY KEY,
+		-- JSON object
+		settings TEXT NOT NULL
+	);
+
+	CREATE VIRTUAL TABLE posts_fts USING fts5 (
+		title, content,
+		content = 'posts', content_rowid = 'seq', tokenize = 'porter unicode61'
+	);
+	CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts (rowid, title, content) VALUES (new.seq, new.title, new.content);
+	END;
+	CREATE TRIGGER posts_fts_delete AFTER DELETE ON posts BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.seq, old.title, old.content);
+	END;
+	CREATE TRIGGER posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+		INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.seq, old.title, old.content);
+		INSERT INTO posts_fts (rowid, title, content) VALUES (new.seq, new.title, new.content);
+	END;`,
+}
+
+func init() {
+	// lower() of SQLite only folds ASCII letters. Titles are matched and
+	// sorted case-insensitively like the other backends do.
+	sqlite.MustRegisterDeterministicScalarFunction("go_lower", 1,
+		func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			switch v := args[0].(type) {
+			case string:
+				return strings.ToLower(v), nil
+			case []byte:
+				return strings.ToLower(string(v)), nil
+			}
+			return args[0], nil
+		})
+}
+
+// sqlitePostsService stores posts in a SQLite database. Previous versions of
+// posts are kept as revisions, and deleted posts stay in the database until
+// they are purged.
+type sqlitePostsService struct {
+	db *sql.DB
+}
+
+// NewSQLitePostsService opens the SQLite database at path, creating it if it
+// does not exist, and brings its schema up to date.
+func NewSQLitePostsService(path string) (*sqlitePostsService, error) {
+	// Writing transactions take the write lock right away, so they never
+	// fail halfway on a lock held by another one.
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"},
+		"_txlock": {"immediate"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLitePostsService: %w", err)
+	}
+
+	svc := &sqlitePostsService{db: db}
+	if err := svc.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("NewSQLitePostsService: %w", err)
+	}
+
+	return svc, nil
+}
+
+// Close closes the database.
+func (svc *sqlitePostsService) Close() error {
+	return svc.db.Close()
+}
+
+// migrate applies all migrations that were not applied yet.
+func (svc *sqlitePostsService) migrate() error {
+	var version int
+	if err := svc.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported (%d)", version, len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		err := svc.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// inTx runs f in a transaction, which is committed if f succeeds and rolled
+// back otherwise.
+func (svc *sqlitePostsService) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := svc.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlQueryer is a database or a transaction.
+type sqlQueryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// queryPosts returns the posts selected by from, which is the FROM clause
+// of a query on the posts table and anything following it, up to ORDER BY,
+// in the order of order, which may be followed by LIMIT and OFFSET.
+func queryPosts(q sqlQueryer, from, order string, args ...any) ([]*Post, error) {
+	rows, err := q.Query(`SELECT posts.id, posts.title, posts.content, posts.created, posts.modified `+
+		from+` ORDER BY `+order, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	byID := make(map[string]*Post)
+	for rows.Next() {
+		var (
+			p                 = new(Post)
+			created, modified int64
+		)
+		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &created, &modified); err != nil {
+			return nil, err
+		}
+		p.CreatedTime = time.Unix(0, created)
+		p.ModifiedTime = time.Unix(0, modified)
+		posts = append(posts, p)
+		byID[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, nil
+	}
+
+	tags, err := q.Query(`SELECT post_id, name FROM tags WHERE post_id IN (SELECT posts.id `+
+		from+` ORDER BY `+order+`) ORDER BY post_id, position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer tags.Close()
+
+	for tags.Next() {
+		var id string
+		var tag Tag
+		if err := tags.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		if p, ok := byID[id]; ok {
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+	return posts, tags.Err()
+}
+
+// getPost returns a post that is not in the trash.
+func getPost(q sqlQueryer, id string) (*Post, error) {
+	posts, err := queryPosts(q, `FROM posts WHERE posts.id = ? AND posts.deleted IS NULL`, `posts.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("post %s: %w", id, fs.ErrNotExist)
+	}
+	return posts[0], nil
+}
+
+// insertPost adds a post with its tags and links. A non-zero deleted time
+// puts it in the trash.
+func insertPost(tx *sql.Tx, p *Post, deleted time.Time) error {
+	var del sql.NullInt64
+	if !deleted.IsZero() {
+		del = sql.NullInt64{Int64: deleted.UnixNano(), Valid: true}
+	}
+
+	_, err := tx.Exec(`INSERT INTO posts (id, title, title_key, content, created, modified, deleted)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Title, normalizeLinkTarget(p.Title), p.Content,
+		p.CreatedTime.UnixNano(), p.ModifiedTime.UnixNano(), del)
+	if err != nil {
+		return err
+	}
+	return writePostRefs(tx, p)
+}
+
+// writePostRefs replaces the tags and links of a post.
+func writePostRefs(tx *sql.Tx, p *Post) error {
+	if _, err := tx.Exec(`DELETE FROM tags WHERE post_id = ?`, p.ID); err != nil {
+		return err
+	}
+	for i, t := range p.Tags {
+		if _, err := tx.Exec(`INSERT INTO tags (post_id, position, name) VALUES (?, ?, ?)`, p.ID, i, t); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM links WHERE post_id = ?`, p.ID); err != nil {
+		return err
+	}
+	for _, link := range ParseWikiLinks(p.Content) {
+		_, err := tx.Exec(`INSERT INTO links (post_id, target, target_key) VALUES (?, ?, ?)`,
+			p.ID, link.Target, normalizeLinkTarget(link.Target))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertRevision(tx *sql.Tx, rev *Revision) error {
+	tags, err := json.Marshal(rev.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO revisions (id, post_id, title, content, tags, modified) VALUES (?, ?, ?, ?, ?, ?)`,
+		rev.ID, rev.PostID, rev.Title, rev.Content, string(tags), rev.ModifiedTime.UnixNano())
+	return err
+}
+
+// Returns a single post by ID.
+func (svc *sqlitePostsService) GetPost(id string) (*Post, error) {
+	p, err := getPost(svc.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetPost: %w", err)
+	}
+	return p, nil
+}
+
+// ftsQuery returns the FTS5 query matching the same posts as a search query:
+// posts with all of its words, and the text in double quotes as a phrase.
+// It is empty if the search query has no words.
+func ftsQuery(q string) string {
+	var clauses []string
+	for i, part := range strings.Split(q, `"`) {
+		words := strings.FieldsFunc(part, func(r rune) bool { return !isWordRune(r) })
+		if len(words) == 0 {
+			continue
+		}
+		if i%2 == 1 {
+			// Inside quotes
+			clauses = append(clauses, `"`+strings.Join(words, " ")+`"`)
+			continue
+		}
+		for _, w := range words {
+			clauses = append(clauses, `"`+w+`"`)
+		}
+	}
+	return strings.Join(clauses, " ")
+}
+
+// Returns a list of all posts matching the options. Search results are
+// ranked by the full-text index.
+func (svc *sqlitePostsService) ListPosts(opts *ListPostOptions) ([]*Post, error) {
+	if opts == nil {
+		opts = &ListPostOptions{}
+	}
+
+	from := `FROM posts`
+	var relevance string
+	var args []any
+	if q := ftsQuery(opts.SearchTerm); q != "" {
+		from += ` JOIN posts_fts ON posts_fts.rowid = posts.seq WHERE posts_fts MATCH ? AND `
+		relevance = fmt.Sprintf(`bm25(posts_fts, %g, 1)`, titleBoost)
+		args = append(args, q)
+	} else {
+		from += ` WHERE `
+	}
+	where, whereArgs := sqlPostsFilter(opts)
+	from += where
+	args = append(args, whereArgs...)
+
+	order, orderArgs := sqlPostsOrder(opts, relevance)
+	args = append(args, orderArgs...)
+
+	posts, err := queryPosts(svc.db, from, order, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ListPosts: %w", err)
+	}
+	return posts, nil
+}
+
+// sqlHasAnyTag returns the condition on posts having at least one of the
+// tags, or any of their descendants, with its arguments.
+func sqlHasAnyTag(tags []string) (string, []any) {
+	var conds []string
+	var args []any
+	for _, t := range tags {
+		t = strings.TrimSuffix(t, TagPathSeparator)
+		// Descendants sort between `t/` and `t0`, which follows the separator
+		conds = append(conds, `tags.name = ? OR (tags.name > ? AND tags.name < ?)`)
+		args = append(args, t, t+TagPathSeparator, t+"0")
+	}
+	return `EXISTS (SELECT 1 FROM tags WHERE tags.post_id = posts.id AND (` +
+		strings.Join(conds, ` OR `) + `))`, args
+}
+
+// sqlHasTag returns the condition on posts having exactly the tag.
+func sqlHasTag(tag Tag) (string, []any) {
+	return `EXISTS (SELECT 1 FROM tags WHERE tags.post_id = posts.id AND tags.name = ?)`, []any{string(tag)}
+}
+
+// sqlPostsFilter returns the conditions on posts not in the trash passing
+// all filters except the search term, like ListPostOptions.matches, with
+// their arguments.
+func sqlPostsFilter(opts *ListPostOptions) (string, []any) {
+	conds := []string{`posts.deleted IS NULL`}
+	var args []any
+	add := func(cond string, condArgs []any) {
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+
+	if len(opts.TagsFilter) > 0 {
+		switch opts.TagMatch {
+		case TagMatchAll:
+			for _, t := range opts.TagsFilter {
+				add(sqlHasAnyTag([]string{t}))
+			}
+		case TagMatchNone:
+			cond, condArgs := sqlHasAnyTag(opts.TagsFilter)
+			add(`NOT `+cond, condArgs)
+		default:
+			add(sqlHasAnyTag(opts.TagsFilter))
+		}
+	}
+	if len(opts.ExcludeTags) > 0 {
+		cond, condArgs := sqlHasAnyTag(opts.ExcludeTags)
+		add(`NOT `+cond, condArgs)
+	}
+
+	for _, t := range opts.TitleTerms {
+		add(`instr(go_lower(posts.title), ?) > 0`, []any{strings.ToLower(t)})
+	}
+
+	if opts.Dir != "" {
+		// Folder tags may have leading and trailing separators
+		dir := strings.Trim(opts.Dir, TagPathSeparator)
+		cond := `EXISTS (SELECT 1 FROM (SELECT trim(substr(tags.name, ?), ?) AS dir FROM tags
+			WHERE tags.post_id = posts.id AND substr(tags.name, 1, ?) = ?)`
+		condArgs := []any{len(DirTagPrefix) + 1, TagPathSeparator, len(DirTagPrefix), DirTagPrefix}
+		if dir != "" {
+			cond += ` WHERE dir = ? OR (dir > ? AND dir < ?)`
+			condArgs = append(condArgs, dir, dir+TagPathSeparator, dir+"0")
+		}
+		add(cond+`)`, condArgs)
+	}
+
+	if opts.ExcludeArchived {
+		cond, condArgs := sqlHasTag(ArchivedTag)
+		add(`NOT `+cond, condArgs)
+	}
+	if opts.ExcludeDrafts {
+		cond, condArgs := sqlHasTag(DraftTag)
+		add(`NOT `+cond, condArgs)
+	}
+
+	for _, r := range []struct {
+		column string
+		after  time.Time
+		before time.Time
+	}{
+		{`posts.created`, opts.CreatedAfter, opts.CreatedBefore},
+		{`posts.modified`, opts.ModifiedAfter, opts.ModifiedBefore},
+	} {
+		if !r.after.IsZero() {
+			add(r.column+` >= ?`, []any{r.after.UnixNano()})
+		}
+		if !r.before.IsZero() {
+			add(r.column+` < ?`, []any{r.before.UnixNano()})
+		}
+	}
+
+	return strings.Join(conds, ` AND `), args
+}
+
+// sqlPostsOrder returns the ORDER BY clause, with LIMIT and OFFSET, ordering
+// and paging posts like ListPostOptions.sortAndPage, with its arguments.
+// relevance is the ranking of search results, if searching.
+func sqlPostsOrder(opts *ListPostOptions, relevance string) (string, []any) {
+	pinned, args := sqlHasTag(PinnedTag)
+	order := []string{pinned + ` DESC`}
+
+	key := opts.SortBy
+	if key == "" {
+		key = SortByRelevance
+	}
+	if key == SortByRelevance && opts.SearchTerm == "" {
+		// There's no relevance without a search
+		key = SortByCreated
+	}
+	column := map[PostSortKey]string{
+		SortByCreated:  `posts.created`,
+		SortByModified: `posts.modified`,
+		SortByTitle:    `go_lower(posts.title)`,
+	}[key]
+	if column != "" {
+		if opts.SortDescending {
+			column += ` DESC`
+		}
+		order = append(order, column)
+	}
+	if relevance != "" {
+		order = append(order, relevance)
+	}
+	order = append(order, `posts.id`)
+
+	clause := strings.Join(order, `, `)
+	if opts.Limit > 0 || opts.Offset > 0 {
+		limit := opts.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		clause += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, max(opts.Offset, 0))
+	}
+	return clause, args
+}
+
+// Create a post. ID, CreatedTime and ModifiedTime will be overwritten if present.
+func (svc *sqlitePostsService) CreatePost(p *Post) error {
+	now := time.Now()
+	id, err := ksuid.NewRandomWithTime(now)
+	if err != nil {
+		return fmt.Errorf("CreatePost: %w", err)
+	}
+
+	p.ID = id.String()
+	p.cleanTags()
+	p.CreatedTime = now
+	p.ModifiedTime = now
+
+	if err := svc.inTx(func(tx *sql.Tx) error {
+		return insertPost(tx, p, time.Time{})
+	}); err != nil {
+		return fmt.Errorf("CreatePost: %w", err)
+	}
+	return nil
+}
+
+// Updates a posts title, content and tags. The version being replaced is kept
+// as a revision.
+func (svc *sqlitePostsService) UpdatePost(p *Post) error {
+	err := svc.inTx(func(tx *sql.Tx) error {
+		prev, err := getPost(tx, p.ID)
+		if err != nil {
+			return err
+		}
+		if p.BaseVersion != "" && p.BaseVersion != prev.Version() {
+			return ErrConflict
+		}
+
+		id, err := ksuid.NewRandomWithTime(prev.ModifiedTime)
+		if err != nil {
+			return err
+		}
+		rev := prev.Revision()
+		rev.ID = id.String()
+		if err := insertRevision(tx, rev); err != nil {
+			return err
+		}
+
+		p.cleanTags()
+		p.ModifiedTime = time.Now()
+
+		_, err = tx.Exec(`UPDATE posts SET title = ?, title_key = ?, content = ?, modified = ? WHERE id = ?`,
+			p.Title, normalizeLinkTarget(p.Title), p.Content, p.ModifiedTime.UnixNano(), p.ID)
+		if err != nil {
+			return err
+		}
+		return writePostRefs(tx, p)
+	})
+	if err != nil {
+		return fmt.Errorf("UpdatePost: %w", err)
+	}
+
+	p.BaseVersion = ""
+	return nil
+}
+
+// Returns all distinct tags of all posts, with their usage.
+func (svc *sqlitePostsService) ListTags(opts *ListTagOptions) ([]*TagInfo, error) {
+	if opts == nil {
+		opts = new(ListTagOptions)
+	}
+
+	posts, err := svc.ListPosts(nil)
+	if err != nil {
+		return nil, fmt.Errorf("ListTags: %w", err)
+	}
+
+	return tagInfos(posts, opts), nil
+}
+
+func (svc *sqlitePostsService) GetPostsFolderTree() (*Node, error) {
+	posts, err := svc.ListPosts(nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+	}
+
+	rows, err := svc.db.Query(`SELECT path, settings FROM folders`)
+	if err != nil {
+		return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+	}
+	defer rows.Close()
+
+	settings := make(map[string]*FolderSettings)
+	for rows.Next() {
+		var dir, b string
+		if err := rows.Scan(&dir, &b); err != nil {
+			return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+		}
+		s := new(FolderSettings)
+		if err := json.Unmarshal([]byte(b), s); err != nil {
+			return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+		}
+		settings[dir] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetPostsFolderTree: %w", err)
+	}
+
+	return buildFolderTree(posts, settings), nil
+}
+
+// Moves a post to the trash.
+func (svc *sqlitePostsService) DeletePost(id string) error {
+	res, err := svc.db.Exec(`UPDATE posts SET deleted = ? WHERE id = ? AND deleted IS NULL`, time.Now().UnixNano(), id)
+	if err := checkAffected(res, err, id); err != nil {
+		return fmt.Errorf("DeletePost: %w", err)
+	}
+	return nil
+}
+
+// checkAffected returns an error if a statement failed or did not change the
+// post.
+func checkAffected(res sql.Result, err error, id string) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("post %s: %w", id, fs.ErrNotExist)
+	}
+	return nil
+}
+
+// Returns a single post from the trash.
+func (svc *sqlitePostsService) GetDeletedPost(id string) (*DeletedPost, error) {
+	var deleted int64
+	err := svc.db.QueryRow(`SELECT deleted FROM posts WHERE id = ? AND deleted IS NOT NULL`, id).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("GetDeletedPost: post %s: %w", id, fs.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("GetDeletedPost: %w", err)
+	}
+
+	posts, err := queryPosts(svc.db, `FROM posts WHERE posts.id = ?`, `posts.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedPost: %w", err)
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("GetDeletedPost: post %s: %w", id, fs.ErrNotExist)
+	}
+	return &DeletedPost{Post: *posts[0], DeletedTime: time.Unix(0, deleted)}, nil
+}
+
+// Returns all posts in the trash, most recently deleted first.
+func (svc *sqlitePostsService) ListDeletedPosts() ([]*DeletedPost, error) {
+	const from = `FROM posts WHERE posts.deleted IS NOT NULL`
+	posts, err := queryPosts(svc.db, from, `posts.deleted DESC, posts.id`)
+	if err != nil {
+		return nil, fmt.Errorf("ListDeletedPosts: %w", err)
+	}
+
+	rows, err := svc.db.Query(`SELECT posts.id, posts.deleted ` + from)
+	if err != nil {
+		return nil, fmt.Errorf("ListDeletedPosts: %w", err)
+	}
+	defer rows.Close()
+
+	deleted := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var t int64
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, fmt.Errorf("ListDeletedPosts: %w", err)
+		}
+		deleted[id] = time.Unix(0, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListDeletedPosts: %w", err)
+	}
+
+	var trashed []*DeletedPost
+	for _, p := range posts {
+		trashed = append(trashed, &DeletedPost{Post: *p, DeletedTime: deleted[p.ID]})
+	}
+	return trashed, nil
+}
+
+// Moves a post from the trash back to the list of posts.
+func (svc *sqlitePostsService) RestorePost(id string) error {
+	res, err := svc.db.Exec(`UPDATE posts SET deleted = NULL WHERE id = ? AND deleted IS NOT NULL`, id)
+	if err := checkAffected(res, err, id); err != nil {
+		return fmt.Errorf("RestorePost: %w", err)
+	}
+	return nil
+}
+
+// Permanently removes a post from the trash, along with its revisions.
+func (svc *sqlitePostsService) PurgePost(id string) error {
+	res, err := svc.db.Exec(`DELETE FROM posts WHERE id = ? AND deleted IS NOT NULL`, id)
+	if err := checkAffected(res, err, id); err != nil {
+		return fmt.Errorf("PurgePost: %w", err)
+	}
+	return nil
+}
+
+// Permanently removes all posts that were deleted before the given time.
+// Returns the IDs of the purged posts.
+func (svc *sqlitePostsService) PurgeDeletedPosts(before time.Time) ([]string, error) {
+	rows, err := svc.db.Query(`SELECT id FROM posts WHERE deleted < ? ORDER BY deleted DESC`, before.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("PurgeDeletedPosts: %w", err)
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("PurgeDeletedPosts: %w", err)
+		}
+		expired = append(expired, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("PurgeDeletedPosts: %w", err)
+	}
+
+	var ids []string
+	for _, id := range expired {
+		if err := svc.PurgePost(id); err != nil {
+			return ids, fmt.Errorf("PurgeDeletedPosts: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func scanRevision(row interface{ Scan(...any) error }) (*Revision, error) {
+	var (
+		rev      = new(Revision)
+		tags     string
+		modified int64
+	)
+	if err := row.Scan(&rev.ID, &rev.PostID, &rev.Title, &rev.Content, &tags, &modified); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &rev.Tags); err != nil {
+		return nil, err
+	}
+	rev.ModifiedTime = time.Unix(0, modified)
+	return rev, nil
+}
+
+const revisionColumns = `id, post_id, title, content, tags, modified`
+
+// Returns all previous versions of a post, newest first. The current version
+// of the post is not included.
+func (svc *sqlitePostsService) ListRevisions(postID string) ([]*Revision, error) {
+	rows, err := svc.db.Query(`SELECT `+revisionColumns+` FROM revisions WHERE post_id = ?
+		ORDER BY modified DESC, id DESC`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("ListRevisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revs []*Revision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ListRevisions: %w", err)
+		}
+		revs = append(revs, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListRevisions: %w", err)
+	}
+	return revs, nil
+}
+
+// Returns a single previous version of a post.
+func (svc *sqlitePostsService) GetRevision(postID, revisionID string) (*Revision, error) {
+	rev, err := scanRevision(svc.db.QueryRow(`SELECT `+revisionColumns+` FROM revisions
+		WHERE post_id = ? AND id = ?`, postID, revisionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("GetRevision: revision %s: %w", revisionID, fs.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("GetRevision: %w", err)
+	}
+	return rev, nil
+}
+
+// resolveLink returns the ID of the post a wiki link target refers to. IDs
+// take precedence over titles, and the oldest post wins if several posts
+// have the same title.
+func resolveLink(q sqlQueryer, target string) (string, error) {
+	target = strings.TrimSpace(target)
+
+	var id string
+	err := q.QueryRow(`SELECT id FROM posts WHERE id = ? AND deleted IS NULL`, target).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = q.QueryRow(`SELECT id FROM posts WHERE title_key = ? AND deleted IS NULL ORDER BY id LIMIT 1`,
+			normalizeLinkTarget(target)).Scan(&id)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%q: %w", target, fs.ErrNotExist)
+	}
+	return id, err
+}
+
+// ResolveLink returns the post a wiki link target refers to, by ID or title.
+func (svc *sqlitePostsService) ResolveLink(target string) (*Post, error) {
+	id, err := resolveLink(svc.db, target)
+	if err != nil {
+		return nil, fmt.Errorf("ResolveLink: %w", err)
+	}
+	return svc.GetPost(id)
+}
+
+// ListBacklinks returns all posts linking to a post.
+func (svc *sqlitePostsService) ListBacklinks(postID string) ([]*Post, error) {
+	post, err := getPost(svc.db, postID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("ListBacklinks: %w", err)
+	}
+
+	rows, err := svc.db.Query(`SELECT DISTINCT links.post_id, links.target FROM links
+		JOIN posts ON posts.id = links.post_id
+		WHERE posts.deleted IS NULL AND links.post_id != ? AND links.target_key IN (?, ?)
+		ORDER BY links.post_id`,
+		postID, normalizeLinkTarget(postID), normalizeLinkTarget(post.Title))
+	if err != nil {
+		return nil, fmt.Errorf("ListBacklinks: %w", err)
+	}
+	defer rows.Close()
+
+	// The link might refer to another post with the same title
+	type link struct{ src, target string }
+	var candidates []link
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.src, &l.target); err != nil {
+			return nil, fmt.Errorf("ListBacklinks: %w", err)
+		}
+		candidates = append(candidates, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListBacklinks: %w", err)
+	}
+	rows.Close()
+
+	var posts []*Post
+	for _, l := range candidates {
+		if n := len(posts); n > 0 && posts[n-1].ID == l.src {
+			continue
+		}
+		if id, err := resolveLink(svc.db, l.target); err != nil || id != postID {
+			continue
+		}
+		p, err := svc.GetPost(l.src)
+		if err != nil {
+			return nil, fmt.Errorf("ListBacklinks: %w", err)
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+// GetFolderSettings returns the settings of a folder. Folders without any
+// settings get the defaults.
+func (svc *sqlitePostsService) GetFolderSettings(dir string) (*FolderSettings, error) {
+	s, err := getFolderSettings(svc.db, dir)
+	if err != nil {
+		return nil, fmt.Errorf("GetFolderSettings: %w", err)
+	}
+	return s, nil
+}
+
+func getFolderSettings(q sqlQueryer, dir string) (*FolderSettings, error) {
+	var b string
+	err := q.QueryRow(`SELECT settings FROM folders WHERE path = ?`, cleanDir(dir)).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return new(FolderSettings), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s := new(FolderSettings)
+	if err := json.Unmarshal([]byte(b), s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// UpdateFolderSettings replaces the settings of a folder. Default settings
+// are not stored.
+func (svc *sqlitePostsService) UpdateFolderSettings(dir string, s *FolderSettings) error {
+	err := svc.changeFolderSettings(dir, func(*FolderSettings) (*FolderSettings, error) {
+		return s, nil
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateFolderSettings: %w", err)
+	}
+	return nil
+}
+
+// ChangeFolderSettings replaces the settings of a folder with those returned
+// by change for the current settings, in a single transaction. Other writes
+// wait for it, so change must not use the service.
+func (svc *sqlitePostsService) ChangeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	if err := svc.changeFolderSettings(dir, change); err != nil {
+		return fmt.Errorf("ChangeFolderSettings: %w", err)
+	}
+	return nil
+}
+
+func (svc *sqlitePostsService) changeFolderSettings(dir string, change func(*FolderSettings) (*FolderSettings, error)) error {
+	dir = cleanDir(dir)
+	return svc.inTx(func(tx *sql.Tx) error {
+		current, err := getFolderSettings(tx, dir)
+		if err != nil {
+			return err
+		}
+		s, err := change(current)
+		if err != nil {
+			return err
+		}
+
+		if s.IsZero() {
+			_, err := tx.Exec(`DELETE FROM folders WHERE path = ?`, dir)
+			return err
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO folders (path, settings) VALUES (?, ?)
+			ON CONFLICT (path) DO UPDATE SET settings = excluded.settings`, dir, string(b))
+		return err
+	})
+}
+
+func (svc *sqlitePostsService) importPost(p *Post, revs []*Revision, deleted time.Time) error {
+	return svc.inTx(func(tx *sql.Tx) error {
+		// Tags, links and revisions go along
+		if _, err := tx.Exec(`DELETE FROM posts WHERE id = ?`, p.ID); err != nil {
+			return err
+		}
+		if err := insertPost(tx, p, deleted); err != nil {
+			return err
+		}
+		for _, rev := range revs {
+			if err := insertRevision(tx, rev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
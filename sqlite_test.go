@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func newTestSQLitePostsService(t *testing.T) *sqlitePostsService {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	svc, err := NewSQLitePostsService(path.Join(dir, SQLiteFileName))
+	is.NoErr(err)
+	t.Cleanup(func() { svc.Close() })
+	return svc
+}
+
+func TestSQLitePostsService(t *testing.T) {
+	is := is.New(t)
+	svc := newTestSQLitePostsService(t)
+
+	a := &Post{Title: "Running nginx", Content: "The web servers run [[Linux]].", Tags: []Tag{"ops", "_dir:/work"}}
+	is.NoErr(svc.CreatePost(a))
+	b := &Post{Title: "Linux", Content: "A kernel, and the systems built on it.", Tags: []Tag{"ops/linux", " ops/linux"}}
+	is.NoErr(svc.CreatePost(b))
+
+	t.Run("get a post", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.GetPost(a.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, a.Title)
+		is.Equal(p.Tags, a.Tags)
+		is.True(p.CreatedTime.Equal(a.CreatedTime))
+		is.Equal(p.Version(), a.Version())
+
+		_, err = svc.GetPost("nope")
+		is.True(errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("tags are cleaned", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.GetPost(b.ID)
+		is.NoErr(err)
+		is.Equal(p.Tags, []Tag{"ops/linux"})
+	})
+
+	t.Run("search", func(t *testing.T) {
+		is := is.New(t)
+		for q, want := range map[string]int{
+			"linux":          2,
+			"servers":        1, // matches "server" regardless of form
+			`"web servers"`:  1,
+			`"servers web"`:  0,
+			"kernel systems": 1,
+			"windows":        0,
+			"!!":             2,
+		} {
+			posts, err := svc.ListPosts(&ListPostOptions{SearchTerm: q})
+			is.NoErr(err)
+			is.Equal(len(posts), want) // q
+		}
+
+		// Titles weigh more than content
+		posts, err := svc.ListPosts(&ListPostOptions{SearchTerm: "linux"})
+		is.NoErr(err)
+		is.Equal(posts[0].ID, b.ID)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := svc.ListPosts(&ListPostOptions{TagsFilter: []string{"ops"}, SortBy: SortByTitle})
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.Equal(posts[0].ID, b.ID)
+
+		posts, err = svc.ListPosts(&ListPostOptions{Dir: "work"})
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+
+		tags, err := svc.ListTags(&ListTagOptions{IgnoreFunctional: true})
+		is.NoErr(err)
+		is.Equal(len(tags), 2)
+	})
+
+	t.Run("links", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.ResolveLink(" linux ")
+		is.NoErr(err)
+		is.Equal(p.ID, b.ID)
+
+		backlinks, err := svc.ListBacklinks(b.ID)
+		is.NoErr(err)
+		is.Equal(len(backlinks), 1)
+		is.Equal(backlinks[0].ID, a.ID)
+
+		_, err = svc.ResolveLink("nope")
+		is.True(errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("updates keep revisions", func(t *testing.T) {
+		is := is.New(t)
+		p, err := svc.GetPost(a.ID)
+		is.NoErr(err)
+		p.BaseVersion = p.Version()
+		p.Title = "Running caddy"
+		is.NoErr(svc.UpdatePost(p))
+
+		revs, err := svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 1)
+		is.Equal(revs[0].Title, "Running nginx")
+
+		rev, err := svc.GetRevision(a.ID, revs[0].ID)
+		is.NoErr(err)
+		is.Equal(rev.Tags, a.Tags)
+
+		posts, err := svc.ListPosts(&ListPostOptions{SearchTerm: "nginx"})
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+
+		// Updates of an older version are rejected
+		p.BaseVersion = a.Version()
+		is.True(errors.Is(svc.UpdatePost(p), ErrConflict))
+	})
+
+	t.Run("folders", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.UpdateFolderSettings("/work/", &FolderSettings{Icon: "bi-briefcase"}))
+		s, err := svc.GetFolderSettings("work")
+		is.NoErr(err)
+		is.Equal(s.Icon, "bi-briefcase")
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(tree.Find("work").Icon(), "bi-briefcase")
+
+		is.True(svc.UpdateFolderSettings("work", &FolderSettings{Icon: "briefcase"}) != nil)
+		is.NoErr(svc.UpdateFolderSettings("work", nil))
+		s, err = svc.GetFolderSettings("work")
+		is.NoErr(err)
+		is.True(s.IsZero())
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(a.ID))
+		is.True(errors.Is(svc.DeletePost(a.ID), fs.ErrNotExist))
+
+		_, err := svc.GetPost(a.ID)
+		is.True(errors.Is(err, fs.ErrNotExist))
+		backlinks, err := svc.ListBacklinks(b.ID)
+		is.NoErr(err)
+		is.Equal(len(backlinks), 0)
+
+		trashed, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(trashed), 1)
+		is.Equal(trashed[0].ID, a.ID)
+
+		is.NoErr(svc.RestorePost(a.ID))
+		_, err = svc.GetPost(a.ID)
+		is.NoErr(err)
+
+		is.NoErr(svc.DeletePost(a.ID))
+		ids, err := svc.PurgeDeletedPosts(time.Now().Add(time.Minute))
+		is.NoErr(err)
+		is.Equal(ids, []string{a.ID})
+
+		revs, err := svc.ListRevisions(a.ID)
+		is.NoErr(err)
+		is.Equal(len(revs), 0)
+		is.True(errors.Is(svc.PurgePost(a.ID), fs.ErrNotExist))
+	})
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	is := is.New(t)
+	svc := newTestSQLitePostsService(t)
+
+	var version int
+	is.NoErr(svc.db.QueryRow("PRAGMA user_version").Scan(&version))
+	is.Equal(version, len(sqliteMigrations))
+
+	// Migrating again does nothing
+	is.NoErr(svc.migrate())
+
+	_, err := svc.db.Exec("PRAGMA user_version = 1000")
+	is.NoErr(err)
+	is.True(svc.migrate() != nil)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// StateTags are the functional tags that can be toggled on the post page.
+var StateTags = []Tag{PinnedTag, ArchivedTag, DraftTag}
+
+// A PostState is a toggle button of the post page.
+type PostState struct {
+	Tag   Tag
+	Label string
+	Icon  string
+	// Whether the post has the state
+	On bool
+}
+
+func postStates(p *Post) []PostState {
+	states := []PostState{
+		{Tag: PinnedTag, Label: "Pinned", Icon: "bi-pin-angle"},
+		{Tag: ArchivedTag, Label: "Archived", Icon: "bi-archive"},
+		{Tag: DraftTag, Label: "Draft", Icon: "bi-pencil-square"},
+	}
+	for i := range states {
+		states[i].On = p.hasTag(states[i].Tag)
+	}
+	return states
+}
+
+// notArchived reports whether a post is not archived, to leave archived posts
+// out of folder trees.
+func notArchived(p *Post) bool {
+	return !p.IsArchived()
+}
+
+// TogglePostState adds one of the StateTags to a post, or removes it if the
+// post already has it.
+func TogglePostState(svc PostsService, postID string, state Tag) (*Post, error) {
+	if !slices.Contains(StateTags, state) {
+		return nil, fmt.Errorf("TogglePostState: invalid state %q", state)
+	}
+
+	post, err := svc.GetPost(postID)
+	if err != nil {
+		return nil, fmt.Errorf("TogglePostState: %w", err)
+	}
+
+	post, err = changePost(svc, post, func(post *Post) (bool, error) {
+		if post.hasTag(state) {
+			post.Tags = slices.DeleteFunc(post.Tags, func(t Tag) bool {
+				return t == state
+			})
+		} else {
+			post.Tags = append(post.Tags, state)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("TogglePostState: %w", err)
+	}
+	return post, nil
+}
+
+// TogglePostStateHandler pins, archives or marks a post as a draft, or
+// undoes it.
+func (app *App) TogglePostStateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+
+		if _, err := TogglePostState(app.posts, postID, Tag(r.FormValue("state"))); err != nil {
+			log.Printf("error: TogglePostStateHandler: %v", err)
+			code := 400
+			if errors.Is(err, fs.ErrNotExist) {
+				code = 404
+			}
+			http.Error(w, fmt.Sprintf("%v", err), code)
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestPostStates(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	app := NewApp(NewPostsService(dir), ":1337")
+
+	var posts []*Post
+	for _, title := range []string{"a", "b", "c"} {
+		p := &Post{Title: title, Tags: []Tag{"_dir:/foo"}}
+		is.NoErr(app.posts.CreatePost(p))
+		posts = append(posts, p)
+	}
+	a, b, c := posts[0], posts[1], posts[2]
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("toggle states", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(do(http.MethodPost, "/posts/"+b.ID+"/state", "state=_pinned").Code, 303)
+		is.Equal(do(http.MethodPost, "/posts/"+c.ID+"/state", "state=_archived").Code, 303)
+		is.Equal(do(http.MethodPost, "/posts/"+a.ID+"/state", "state=_draft").Code, 303)
+		is.Equal(do(http.MethodPost, "/posts/"+a.ID+"/state", "state=_draft").Code, 303)
+
+		p, err := app.posts.GetPost(a.ID)
+		is.NoErr(err)
+		is.Equal(p.Tags, []Tag{"_dir:/foo"})
+
+		is.Equal(do(http.MethodPost, "/posts/"+a.ID+"/state", "state=_dir:/bar").Code, 400)
+		is.Equal(do(http.MethodPost, "/posts/nope/state", "state=_draft").Code, 404)
+	})
+
+	t.Run("pinned posts come first", func(t *testing.T) {
+		is := is.New(t)
+		list, err := app.posts.ListPosts(&ListPostOptions{SortBy: SortByTitle})
+		is.NoErr(err)
+		is.Equal(list[0].ID, b.ID)
+		is.Equal(list[1].ID, a.ID)
+
+		is.NoErr(SetFolderOrder(app.posts, "foo", []string{c.ID, a.ID}))
+		tree, err := app.posts.GetPostsFolderTree()
+		is.NoErr(err)
+		foo := tree.Find("foo")
+		is.Equal(foo.Value[0].ID, b.ID)
+		is.Equal(foo.Value[1].ID, c.ID)
+	})
+
+	t.Run("archived posts are hidden unless asked for", func(t *testing.T) {
+		is := is.New(t)
+		for url, want := range map[string]bool{
+			"/":                   false,
+			"/?q=c":               false,
+			"/?archived=1":        true,
+			"/?archived=false":    false,
+			"/?q=tag:_archived":   true,
+			"/?q=c&archived=true": true,
+		} {
+			w := do(http.MethodGet, url, "")
+			is.Equal(w.Code, 200)
+			// Links of the post list, not the sidebar
+			listed := strings.Contains(w.Body.String(), `<a href="/posts/`+c.ID+`" hx-get=`)
+			is.Equal(listed, want)
+		}
+	})
+
+	t.Run("archived posts are left out of folder trees unless asked for", func(t *testing.T) {
+		is := is.New(t)
+		for url, want := range map[string]bool{
+			"/":                          false,
+			"/dirs/foo":                  false,
+			"/dirs/foo?archived=true":    true,
+			"/api/v1/tree":               false,
+			"/api/v1/tree?archived=true": true,
+		} {
+			w := do(http.MethodGet, url, "")
+			is.Equal(w.Code, 200)
+			is.Equal(strings.Contains(w.Body.String(), c.ID), want)
+		}
+		is.Equal(do(http.MethodGet, "/dirs/foo?archived=yes", "").Code, 400)
+		is.Equal(do(http.MethodGet, "/api/v1/tree?archived=yes", "").Code, 400)
+	})
+
+	t.Run("invalid archived values are rejected", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(do(http.MethodGet, "/api/v1/posts?archived=yes", "").Code, 400)
+		w := do(http.MethodGet, "/?archived=yes", "")
+		is.True(strings.Contains(w.Body.String(), "invalid archived &#34;yes&#34;"))
+	})
+
+	t.Run("drafts are left out of API listings unless asked for", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(do(http.MethodPost, "/posts/"+a.ID+"/state", "state=_draft").Code, 303)
+		for url, want := range map[string]bool{
+			"/api/v1/posts":              false,
+			"/api/v1/posts?drafts=true":  true,
+			"/api/v1/posts?q=tag:_draft": true,
+			"/api/v1/posts?tags=_draft":  true,
+		} {
+			var list APIPostList
+			w := do(http.MethodGet, url, "")
+			is.Equal(w.Code, 200)
+			is.NoErr(json.NewDecoder(w.Body).Decode(&list))
+			var listed bool
+			for _, p := range list.Posts {
+				listed = listed || p.ID == a.ID
+			}
+			is.Equal(listed, want)
+		}
+		is.Equal(do(http.MethodGet, "/api/v1/posts?drafts=maybe", "").Code, 400)
+
+		// But shown on the index page
+		w := do(http.MethodGet, "/", "")
+		is.True(strings.Contains(w.Body.String(), `<a href="/posts/`+a.ID+`" hx-get=`))
+	})
+
+	t.Run("post page shows states", func(t *testing.T) {
+		is := is.New(t)
+		w := do(http.MethodGet, "/posts/"+b.ID, "")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `aria-pressed="true"`))
+		is.True(strings.Contains(w.Body.String(), `title="Pinned"`))
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IDs of posts and revisions are plain ksuids, and never paths
+var plainIDPattern = regexp.MustCompile(`^\w+$`)
+
+// Infix of the names of temporary files written by writeFileAtomic
+const tempFileInfix = ".tmp-"
+
+// writeFileAtomic writes a file by writing a hidden temporary file next to it
+// first, and renaming it once its contents are safely on disk. A crash or a
+// full disk never leaves the file half written.
+func writeFileAtomic(name string, b []byte, perm os.FileMode) error {
+	dir := path.Dir(name)
+	f, err := os.CreateTemp(dir, "."+path.Base(name)+tempFileInfix+"*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // fails once renamed
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	// The rename itself is only durable once the directory is synced
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// isTempFile reports whether a file name is one of a temporary file written
+// by writeFileAtomic.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempFileInfix)
+}
+
+// postLocks serializes changes to the same post, while changes to different
+// posts go ahead in parallel.
+type postLocks struct {
+	mu    sync.Mutex
+	locks map[string]*postLock
+}
+
+type postLock struct {
+	sync.Mutex
+	// Number of holders and waiters
+	refs int
+}
+
+func newPostLocks() *postLocks {
+	return &postLocks{locks: make(map[string]*postLock)}
+}
+
+// lock locks a post, and returns the function unlocking it.
+func (l *postLocks) lock(id string) (unlock func()) {
+	l.mu.Lock()
+	pl, ok := l.locks[id]
+	if !ok {
+		pl = new(postLock)
+		l.locks[id] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if pl.refs--; pl.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "foo")
+	is.NoErr(writeFileAtomic(name, []byte("one"), DefaultFileMode))
+	is.NoErr(writeFileAtomic(name, []byte("two"), DefaultFileMode))
+
+	b, err := os.ReadFile(name)
+	is.NoErr(err)
+	is.Equal(string(b), "two")
+
+	fi, err := os.Stat(name)
+	is.NoErr(err)
+	is.Equal(fi.Mode().Perm(), DefaultFileMode)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+
+	is.True(writeFileAtomic(path.Join(dir, "nope", "foo"), nil, DefaultFileMode) != nil)
+}
+
+func TestPostLocks(t *testing.T) {
+	is := is.New(t)
+	locks := newPostLocks()
+
+	var (
+		wg      sync.WaitGroup
+		counter int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer locks.lock("a")()
+			counter++
+		}()
+	}
+	wg.Wait()
+
+	is.Equal(counter, 50)
+	is.Equal(len(locks.locks), 0)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validateTagName checks that a tag name can be used in the comma separated
+// tags field of the post form.
+func validateTagName(tag Tag) error {
+	s := strings.TrimSpace(string(tag))
+	if s == "" {
+		return errors.New("tag name is required")
+	}
+	if strings.Contains(s, ",") {
+		return fmt.Errorf("tag name %q must not contain commas", s)
+	}
+	return nil
+}
+
+// ListPostsWithTags returns all posts having any of the tags.
+func ListPostsWithTags(svc PostsService, tags []Tag) ([]*Post, error) {
+	filter := make([]string, len(tags))
+	for i, t := range tags {
+		filter[i] = string(t)
+	}
+	return svc.ListPosts(&ListPostOptions{
+		TagsFilter: filter,
+		TagMatch:   TagMatchAny,
+		SortBy:     SortByTitle,
+	})
+}
+
+// replaceTag returns the tag with its first matching parent in tags replaced
+// with another tag, keeping the child path. ok is false if no tag matches.
+func replaceTag(t Tag, tags []Tag, with Tag) (replaced Tag, ok bool) {
+	for _, parent := range tags {
+		if t.IsWithin(string(parent)) {
+			return with + t[len(parent):], true
+		}
+	}
+	return t, false
+}
+
+// replaceTags replaces the tags, and the tags nested below them, in all posts
+// having them with another tag, or removes them if the other tag is empty. It
+// returns the updated posts.
+func replaceTags(svc PostsService, tags []Tag, with Tag) ([]*Post, error) {
+	posts, err := ListPostsWithTags(svc, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []*Post
+	for _, p := range posts {
+		var changed bool
+		p, err := changePost(svc, p, func(p *Post) (bool, error) {
+			changed = false
+			var newTags []Tag
+			for _, t := range p.Tags {
+				if replaced, ok := replaceTag(t, tags, with); !ok {
+					newTags = append(newTags, t)
+				} else {
+					changed = true
+					if with != "" {
+						// Keep the position of the first replaced tag.
+						// Duplicates are removed on update.
+						newTags = append(newTags, replaced)
+					}
+				}
+			}
+			p.Tags = newTags
+			return changed, nil
+		})
+		if err != nil {
+			return updated, err
+		}
+		if changed {
+			updated = append(updated, p)
+		}
+	}
+
+	return updated, nil
+}
+
+// RenameTag renames a tag, and moves the tags nested below it, in all posts
+// having them. It returns the updated posts.
+func RenameTag(svc PostsService, from, to Tag) ([]*Post, error) {
+	posts, err := MergeTags(svc, []Tag{from}, to)
+	if err != nil {
+		return posts, fmt.Errorf("RenameTag: %w", err)
+	}
+	return posts, nil
+}
+
+// MergeTags replaces several tags with a single tag in all posts having any
+// of them. It returns the updated posts.
+func MergeTags(svc PostsService, tags []Tag, into Tag) ([]*Post, error) {
+	into = Tag(strings.TrimSpace(string(into)))
+	if err := validateTagName(into); err != nil {
+		return nil, fmt.Errorf("MergeTags: %w", err)
+	}
+
+	posts, err := replaceTags(svc, tags, into)
+	if err != nil {
+		return posts, fmt.Errorf("MergeTags: %w", err)
+	}
+	return posts, nil
+}
+
+// DeleteTag removes a tag, and the tags nested below it, from all posts
+// having them. It returns the updated posts.
+func DeleteTag(svc PostsService, tag Tag) ([]*Post, error) {
+	posts, err := replaceTags(svc, []Tag{tag}, "")
+	if err != nil {
+		return posts, fmt.Errorf("DeleteTag: %w", err)
+	}
+	return posts, nil
+}
+
+// Actions of the tags page
+const (
+	TagActionRename = "rename"
+	TagActionDelete = "delete"
+)
+
+// A TagChange is a change of tags requested on the tags page, previewed
+// before it is applied.
+type TagChange struct {
+	Action string
+	Tags   []Tag
+	To     Tag
+	// The posts that will be updated
+	Posts []*Post
+}
+
+// IsMerge reports whether several tags are renamed into one.
+func (c *TagChange) IsMerge() bool {
+	return c.Action == TagActionRename && len(c.Tags) > 1
+}
+
+func tagChangeFromRequest(r *http.Request) (*TagChange, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	c := &TagChange{
+		Action: r.PostForm.Get("action"),
+		To:     Tag(strings.TrimSpace(r.PostForm.Get("to"))),
+	}
+	for _, t := range r.PostForm["tags"] {
+		c.Tags = append(c.Tags, Tag(t))
+	}
+
+	if len(c.Tags) == 0 {
+		return nil, errors.New("no tags selected")
+	}
+	switch c.Action {
+	case TagActionRename:
+		if err := validateTagName(c.To); err != nil {
+			return nil, err
+		}
+	case TagActionDelete:
+	default:
+		return nil, fmt.Errorf("unknown action %q", c.Action)
+	}
+
+	return c, nil
+}
+
+// apply performs the change, returning the updated posts.
+func (c *TagChange) apply(svc PostsService) ([]*Post, error) {
+	switch {
+	case c.Action == TagActionDelete:
+		var updated []*Post
+		for _, t := range c.Tags {
+			posts, err := DeleteTag(svc, t)
+			updated = append(updated, posts...)
+			if err != nil {
+				return updated, err
+			}
+		}
+		return updated, nil
+	case c.IsMerge():
+		return MergeTags(svc, c.Tags, c.To)
+	default:
+		return RenameTag(svc, c.Tags[0], c.To)
+	}
+}
+
+// A TagCloudItem is a tag in the tag cloud, sized from 1 to TagCloudSizes by
+// how often it is used.
+type TagCloudItem struct {
+	*TagInfo
+	Size int
+}
+
+const TagCloudSizes = 5
+
+// tagCloud sizes tags on a logarithmic scale of their counts.
+func tagCloud(tags []*TagInfo) []TagCloudItem {
+	var max int
+	for _, t := range tags {
+		if t.Count > max {
+			max = t.Count
+		}
+	}
+
+	cloud := make([]TagCloudItem, len(tags))
+	for i, t := range tags {
+		cloud[i] = TagCloudItem{TagInfo: t, Size: 1}
+		if max > 1 {
+			cloud[i].Size += int(math.Round((TagCloudSizes - 1) * math.Log(float64(t.Count)) / math.Log(float64(max))))
+		}
+	}
+	return cloud
+}
+
+func (app *App) renderTagsPage(w http.ResponseWriter, r *http.Request, change *TagChange, changeErr error) {
+	opts := &ListTagOptions{IgnoreFunctional: r.FormValue("functional") == ""}
+	sortBy, err := ParseTagSortKey(r.FormValue("sort"))
+	if err != nil {
+		changeErr = err
+	}
+	opts.SortBy = sortBy
+
+	tags, err := app.posts.ListTags(opts)
+	if err != nil {
+		log.Printf("error: TagsHandler: %v", err)
+		http.Error(w, fmt.Sprintf("%v", err), 500)
+		return
+	}
+
+	// The cloud is always in alphabetical order
+	byName := append([]*TagInfo(nil), tags...)
+	sort.Slice(byName, func(i, j int) bool {
+		return byName[i].Name < byName[j].Name
+	})
+
+	updated, _ := strconv.Atoi(r.FormValue("updated"))
+
+	locals := app.buildLocals(struct {
+		Tags       []*TagInfo
+		Cloud      []TagCloudItem
+		SortBy     TagSortKey
+		Functional bool
+		Change     *TagChange
+		Error      error
+		// Number of posts updated by the last change
+		Updated int
+	}{
+		Tags:       tags,
+		Cloud:      tagCloud(byName),
+		SortBy:     opts.SortBy,
+		Functional: !opts.IgnoreFunctional,
+		Change:     change,
+		Error:      changeErr,
+		Updated:    updated,
+	})
+
+	if err := app.templates.ExecuteTemplate(w, "tags.html", locals); err != nil {
+		log.Printf("error: template: %v", err)
+	}
+}
+
+// TagsHandler shows the tag index and cloud.
+func (app *App) TagsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.renderTagsPage(w, r, nil, nil)
+	}
+}
+
+// ChangeTagsHandler previews a change of tags, or applies it once confirmed.
+func (app *App) ChangeTagsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		change, err := tagChangeFromRequest(r)
+		if err != nil {
+			w.WriteHeader(400)
+			app.renderTagsPage(w, r, nil, err)
+			return
+		}
+
+		if r.PostForm.Get("confirm") == "" {
+			change.Posts, err = ListPostsWithTags(app.posts, change.Tags)
+			if err != nil {
+				log.Printf("error: ChangeTagsHandler: %v", err)
+				http.Error(w, fmt.Sprintf("%v", err), 500)
+				return
+			}
+			app.renderTagsPage(w, r, change, nil)
+			return
+		}
+
+		updated, err := change.apply(app.posts)
+		if err != nil {
+			log.Printf("error: ChangeTagsHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 500)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/tags?updated=%d", len(updated)), http.StatusSeeOther)
+	}
+}
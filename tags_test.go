@@ -0,0 +1,230 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTagChanges(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	a := &Post{Title: "a", Tags: []Tag{"linux", "nginx", "_dir:/foo"}}
+	is.NoErr(svc.CreatePost(a))
+	b := &Post{Title: "b", Tags: []Tag{"Linux", "debian"}}
+	is.NoErr(svc.CreatePost(b))
+	c := &Post{Title: "c", Tags: []Tag{"nginx"}}
+	is.NoErr(svc.CreatePost(c))
+
+	tags := func(id string) []Tag {
+		p, err := svc.GetPost(id)
+		is.NoErr(err)
+		return p.Tags
+	}
+
+	t.Run("rename a tag", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := RenameTag(svc, "nginx", "web server")
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.Equal(tags(a.ID), []Tag{"linux", "web server", "_dir:/foo"})
+		is.Equal(tags(c.ID), []Tag{"web server"})
+	})
+
+	t.Run("invalid tag names", func(t *testing.T) {
+		is := is.New(t)
+		_, err := RenameTag(svc, "linux", " ")
+		is.True(err != nil)
+		_, err = RenameTag(svc, "linux", "a,b")
+		is.True(err != nil)
+	})
+
+	t.Run("merge tags", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := MergeTags(svc, []Tag{"Linux", "linux", "debian"}, "linux")
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.Equal(tags(a.ID), []Tag{"linux", "web server", "_dir:/foo"})
+		is.Equal(tags(b.ID), []Tag{"linux"})
+	})
+
+	t.Run("delete a tag", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := DeleteTag(svc, "web server")
+		is.NoErr(err)
+		is.Equal(len(posts), 2)
+		is.Equal(tags(a.ID), []Tag{"linux", "_dir:/foo"})
+		is.Equal(len(tags(c.ID)), 0)
+	})
+
+	t.Run("rename a parent tag", func(t *testing.T) {
+		is := is.New(t)
+		d := &Post{Title: "d", Tags: []Tag{"lang/go", "lang/go/testing", "lang/golang"}}
+		is.NoErr(svc.CreatePost(d))
+
+		posts, err := RenameTag(svc, "lang/go", "go")
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(tags(d.ID), []Tag{"go", "go/testing", "lang/golang"})
+
+		_, err = DeleteTag(svc, "go")
+		is.NoErr(err)
+		is.Equal(tags(d.ID), []Tag{"lang/golang"})
+		is.NoErr(svc.DeletePost(d.ID))
+	})
+
+	t.Run("tags are counted", func(t *testing.T) {
+		is := is.New(t)
+		tags, err := svc.ListTags(&ListTagOptions{IgnoreFunctional: true})
+		is.NoErr(err)
+		is.Equal(len(tags), 1)
+		is.Equal(tags[0].Name, Tag("linux"))
+		is.Equal(tags[0].Count, 2)
+	})
+}
+
+func TestChangeTagsHandler(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := os.MkdirTemp("", "")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	app := NewApp(NewPostsService(dir), ":1337")
+	is.NoErr(app.posts.CreatePost(&Post{Title: "a", Tags: []Tag{"foo", "bar"}}))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/tags", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("preview", func(t *testing.T) {
+		is := is.New(t)
+		w := post("action=rename&tags=foo&tags=bar&to=baz")
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), "in 1 post:"))
+
+		posts, err := app.posts.ListPosts(&ListPostOptions{TagsFilter: []string{"baz"}})
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		is := is.New(t)
+		w := post("action=rename&tags=foo&tags=bar&to=baz&confirm=1")
+		is.Equal(w.Code, 303)
+		is.Equal(w.Header().Get("Location"), "/tags?updated=1")
+
+		posts, err := app.posts.ListPosts(&ListPostOptions{TagsFilter: []string{"baz"}})
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(posts[0].Tags, []Tag{"baz"})
+	})
+
+	t.Run("invalid changes", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(post("action=delete").Code, 400)
+		is.Equal(post("action=rename&tags=baz").Code, 400)
+		is.Equal(post("action=explode&tags=baz").Code, 400)
+	})
+}
+
+func TestTagLinks(t *testing.T) {
+	is := is.New(t)
+
+	app := NewApp(NewMemoryPostsService(), ":1337")
+	is.NoErr(app.posts.CreatePost(&Post{Title: "a", Tags: []Tag{`say "hi" now`}}))
+
+	for _, url := range []string{"/", "/tags"} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		is.Equal(w.Code, 200)
+		is.True(strings.Contains(w.Body.String(), `href="/?q=tag%3a%22say%20%5c%22hi%5c%22%20now%22"`))
+	}
+}
+
+func TestTagInfos(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	posts := []*Post{
+		{Tags: []Tag{"b", "_dir:/x"}, ModifiedTime: day(3)},
+		{Tags: []Tag{"b", "c"}, ModifiedTime: day(1)},
+		{Tags: []Tag{"a", "c"}, ModifiedTime: day(2)},
+		{Tags: []Tag{"c"}, ModifiedTime: day(1)},
+	}
+	names := func(tags []*TagInfo) (s []Tag) {
+		for _, t := range tags {
+			s = append(s, t.Name)
+		}
+		return s
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		is := is.New(t)
+		tags := tagInfos(posts, &ListTagOptions{})
+		is.Equal(tags, []*TagInfo{
+			{Name: "_dir:/x", Count: 1, LastUsed: day(3)},
+			{Name: "a", Count: 1, LastUsed: day(2)},
+			{Name: "b", Count: 2, LastUsed: day(3)},
+			{Name: "c", Count: 3, LastUsed: day(2)},
+		})
+	})
+
+	t.Run("sort by count", func(t *testing.T) {
+		is := is.New(t)
+		tags := tagInfos(posts, &ListTagOptions{IgnoreFunctional: true, SortBy: TagSortByCount})
+		is.Equal(names(tags), []Tag{"c", "b", "a"})
+	})
+
+	t.Run("sort by last used", func(t *testing.T) {
+		is := is.New(t)
+		tags := tagInfos(posts, &ListTagOptions{IgnoreFunctional: true, SortBy: TagSortByLastUsed})
+		is.Equal(names(tags), []Tag{"b", "a", "c"})
+	})
+
+	t.Run("cloud sizes", func(t *testing.T) {
+		is := is.New(t)
+		cloud := tagCloud(tagInfos(posts, &ListTagOptions{}))
+		var sizes []int
+		for _, item := range cloud {
+			sizes = append(sizes, item.Size)
+		}
+		is.Equal(sizes, []int{1, 1, 4, 5})
+	})
+}
+
+func TestBuildTagTree(t *testing.T) {
+	is := is.New(t)
+
+	tree := BuildTagTree([]*Post{
+		{ID: "a", Tags: []Tag{"lang/go", "_draft"}},
+		{ID: "b", Tags: []Tag{"lang/go/testing", "lang/rust"}},
+	}, &ListTagOptions{IgnoreFunctional: true})
+
+	is.Equal(len(tree.Children), 1)
+	lang := tree.Children[0]
+	is.Equal(lang.Path, "lang")
+	is.Equal(lang.PostCount(), 2)
+	is.Equal(len(lang.Value), 0)
+
+	is.Equal(len(lang.Children), 2)
+	golang := lang.Children[0]
+	is.Equal(golang.Path, "lang/go")
+	is.Equal(golang.PostCount(), 2)
+	is.Equal(len(golang.Value), 1)
+}
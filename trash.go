@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// Name of the directory, relative to the posts root, where deleted posts are
+// kept until they are restored or purged.
+const TrashDirName = ".trash"
+
+type DeletedPost struct {
+	Post
+	DeletedTime time.Time
+}
+
+func (svc postsService) trashDir() string {
+	return path.Join(svc.root, TrashDirName)
+}
+
+// Moves a post to the trash. The time of deletion is recorded as the
+// modification time of the trashed file.
+func (svc postsService) DeletePost(id string) error {
+	defer svc.locks.lock(id)()
+
+	filepath, err := findPostFile(svc.root, id)
+	if err != nil {
+		return fmt.Errorf("DeletePost: %w", err)
+	}
+
+	if err := svc.moveToTrash(filepath, time.Now()); err != nil {
+		return fmt.Errorf("DeletePost: %w", err)
+	}
+	svc.index.remove(id)
+
+	return nil
+}
+
+// moveToTrash moves a post file to the trash, deleted at the given time. The
+// time is set on the file before it is moved, so files in the trash always
+// have their time of deletion.
+func (svc postsService) moveToTrash(filepath string, deleted time.Time) error {
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(svc.trashDir(), 0750); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(filepath, deleted, deleted); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath, path.Join(svc.trashDir(), path.Base(filepath))); err != nil {
+		// The post is kept, as it was
+		os.Chtimes(filepath, fi.ModTime(), fi.ModTime())
+		return err
+	}
+	return nil
+}
+
+// Returns a single post from the trash.
+func (svc postsService) GetDeletedPost(id string) (*DeletedPost, error) {
+	p, err := svc.getDeletedPost(id)
+	if err != nil {
+		return nil, fmt.Errorf("GetDeletedPost: %w", err)
+	}
+	return p, nil
+}
+
+func (svc postsService) getDeletedPost(id string) (*DeletedPost, error) {
+	filepath, err := findPostFile(svc.trashDir(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := readPostFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeletedPost{Post: *p, DeletedTime: fi.ModTime()}, nil
+}
+
+// Returns all posts in the trash, most recently deleted first. Files in the
+// trash that can not be read are logged and left out.
+func (svc postsService) ListDeletedPosts() ([]*DeletedPost, error) {
+	entries, err := os.ReadDir(svc.trashDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("ListDeletedPosts: %w", err)
+	}
+
+	var posts []*DeletedPost
+	for _, e := range entries {
+		if e.IsDir() || isTempFile(e.Name()) {
+			continue
+		}
+		p, err := svc.getDeletedPost(postIDFromFilename(e.Name()))
+		if err != nil {
+			log.Printf("error: ListDeletedPosts: %s: %v", e.Name(), err)
+			continue
+		}
+		posts = append(posts, p)
+	}
+
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].DeletedTime.After(posts[j].DeletedTime)
+	})
+
+	return posts, nil
+}
+
+// Moves a post from the trash back to the list of posts.
+func (svc postsService) RestorePost(id string) error {
+	defer svc.locks.lock(id)()
+
+	trashed, err := findPostFile(svc.trashDir(), id)
+	if err != nil {
+		return fmt.Errorf("RestorePost: %w", err)
+	}
+
+	if _, err := findPostFile(svc.root, id); err == nil {
+		return fmt.Errorf("RestorePost: %w", fs.ErrExist)
+	}
+
+	if err := os.Rename(trashed, path.Join(svc.root, path.Base(trashed))); err != nil {
+		return fmt.Errorf("RestorePost: %w", err)
+	}
+	svc.refresh(id)
+
+	return nil
+}
+
+// Permanently removes a post from the trash, along with its revisions.
+func (svc postsService) PurgePost(id string) error {
+	defer svc.locks.lock(id)()
+
+	trashed, err := findPostFile(svc.trashDir(), id)
+	if err != nil {
+		return fmt.Errorf("PurgePost: %w", err)
+	}
+
+	if err := os.Remove(trashed); err != nil {
+		return fmt.Errorf("PurgePost: %w", err)
+	}
+	if err := os.RemoveAll(svc.revisionsDir(id)); err != nil {
+		return fmt.Errorf("PurgePost: %w", err)
+	}
+
+	return nil
+}
+
+// Permanently removes all posts that were deleted before the given time.
+// Returns the IDs of the purged posts.
+func (svc postsService) PurgeDeletedPosts(before time.Time) ([]string, error) {
+	posts, err := svc.ListDeletedPosts()
+	if err != nil {
+		return nil, fmt.Errorf("PurgeDeletedPosts: %w", err)
+	}
+
+	var ids []string
+	for _, p := range posts {
+		if !p.DeletedTime.Before(before) {
+			continue
+		}
+		if err := svc.PurgePost(p.ID); err != nil {
+			return ids, fmt.Errorf("PurgeDeletedPosts: %w", err)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	return ids, nil
+}
+
+// PurgeExpiredPosts permanently removes posts that have been in the trash
+// for longer than the configured retention period.
+func (app *App) PurgeExpiredPosts() {
+	if app.trashRetention <= 0 {
+		return
+	}
+
+	ids, err := app.posts.PurgeDeletedPosts(time.Now().Add(-app.trashRetention))
+	if err != nil {
+		log.Printf("error: failed to purge trash: %v", err)
+	}
+	for _, id := range ids {
+		app.removeAttachments(id)
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d posts from the trash", len(ids))
+	}
+}
+
+// Runs PurgeExpiredPosts and RemoveOrphanedAttachments at the given interval
+// until the program exits.
+func (app *App) purgeTrashPeriodically(interval time.Duration) {
+	app.PurgeExpiredPosts()
+	app.RemoveOrphanedAttachments()
+	for range time.Tick(interval) {
+		app.PurgeExpiredPosts()
+		app.RemoveOrphanedAttachments()
+	}
+}
+
+func (app *App) DeletePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		if err := app.posts.DeletePost(postID); err != nil {
+			log.Printf("error: DeletePostHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 404)
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func (app *App) TrashHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		posts, err := app.posts.ListDeletedPosts()
+		if err != nil {
+			log.Printf("error: TrashHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 500)
+			return
+		}
+
+		locals := app.buildLocals(struct {
+			Posts     []*DeletedPost
+			Retention time.Duration
+		}{
+			Posts:     posts,
+			Retention: app.trashRetention,
+		})
+
+		if err := app.templates.ExecuteTemplate(w, "trash.html", locals); err != nil {
+			log.Printf("error: template: %v", err)
+		}
+	}
+}
+
+func (app *App) RestorePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		if err := app.posts.RestorePost(postID); err != nil {
+			log.Printf("error: RestorePostHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+
+		http.Redirect(w, r, "/posts/"+postID, http.StatusSeeOther)
+	}
+}
+
+func (app *App) PurgePostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, _ := r.Context().Value("id").(string)
+		if err := app.posts.PurgePost(postID); err != nil {
+			log.Printf("error: PurgePostHandler: %v", err)
+			http.Error(w, fmt.Sprintf("%v", err), 400)
+			return
+		}
+		app.removeAttachments(postID)
+
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTrash(t *testing.T) {
+	is := is.New(t)
+
+	tmpdir, err := os.MkdirTemp("", "posts")
+	is.NoErr(err)
+	defer os.RemoveAll(tmpdir)
+
+	svc := NewPostsService(tmpdir)
+
+	post := &Post{
+		Title:   "foo",
+		Content: "bar",
+		Tags:    []Tag{"a", "_dir:/foo"},
+	}
+	is.NoErr(svc.CreatePost(post))
+	is.NoErr(svc.CreatePost(&Post{Title: "keep", Tags: []Tag{"b"}}))
+
+	t.Run("delete a post", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(post.ID))
+
+		_, err := svc.GetPost(post.ID)
+		is.True(err != nil) // post is gone
+	})
+
+	t.Run("deleting a missing post fails", func(t *testing.T) {
+		is := is.New(t)
+		is.True(svc.DeletePost("nope") != nil)
+	})
+
+	t.Run("trashed posts are ignored", func(t *testing.T) {
+		is := is.New(t)
+
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(posts[0].Title, "keep")
+
+		tags, err := svc.ListTags(nil)
+		is.NoErr(err)
+		is.Equal(len(tags), 1)
+		is.Equal(tags[0].Name, Tag("b"))
+
+		tree, err := svc.GetPostsFolderTree()
+		is.NoErr(err)
+		is.Equal(len(tree.Children), 0)
+	})
+
+	t.Run("list deleted posts", func(t *testing.T) {
+		is := is.New(t)
+		posts, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(posts[0].ID, post.ID)
+		is.Equal(posts[0].Title, "foo")
+		is.True(time.Since(posts[0].DeletedTime) < time.Minute)
+	})
+
+	t.Run("unreadable files in the trash are skipped", func(t *testing.T) {
+		is := is.New(t)
+		bad := path.Join(tmpdir, TrashDirName, "broken")
+		is.NoErr(os.WriteFile(bad, []byte("{not json"), 0640))
+		defer os.Remove(bad)
+
+		posts, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(posts), 1)
+		is.Equal(posts[0].ID, post.ID)
+	})
+
+	t.Run("restore a post", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.RestorePost(post.ID))
+
+		p, err := svc.GetPost(post.ID)
+		is.NoErr(err)
+		is.Equal(p.Title, "foo")
+
+		posts, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+	})
+
+	t.Run("purge a post", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(svc.DeletePost(post.ID))
+		is.NoErr(svc.PurgePost(post.ID))
+
+		posts, err := svc.ListDeletedPosts()
+		is.NoErr(err)
+		is.Equal(len(posts), 0)
+		is.True(svc.RestorePost(post.ID) != nil) // can't restore a purged post
+	})
+
+	t.Run("purge posts older than retention", func(t *testing.T) {
+		is := is.New(t)
+
+		posts, err := svc.ListPosts(nil)
+		is.NoErr(err)
+		is.NoErr(svc.DeletePost(posts[0].ID))
+
+		ids, err := svc.PurgeDeletedPosts(time.Now().Add(-time.Hour))
+		is.NoErr(err)
+		is.Equal(len(ids), 0) // deleted too recently
+
+		ids, err = svc.PurgeDeletedPosts(time.Now().Add(time.Second))
+		is.NoErr(err)
+		is.Equal(ids, []string{posts[0].ID})
+	})
+}